@@ -67,7 +67,9 @@ func main() {
 
 	go func() {
 		time.Sleep(time.Second * 10)
-		err := f.ShutDown()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		err := f.ShutDown(ctx)
 		if err != nil {
 			logger.Info(err)
 		}
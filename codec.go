@@ -0,0 +1,135 @@
+package eventbusclient
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ContentTypeJSON content type of the default codec
+const ContentTypeJSON = "application/json"
+
+// EntityIdHeader header carrying the payload entity id when the codec is not JSON
+const EntityIdHeader = "entityId"
+
+// ErrTypeRequired the codec can not decode without the Go type of the data, register it in an EventRegistry
+var ErrTypeRequired = errors.New("codec requires the type of the data to decode it")
+
+// UnsupportedContentTypeError no codec is registered for the content type
+type UnsupportedContentTypeError struct {
+	ContentType string
+}
+
+func (e *UnsupportedContentTypeError) Error() string {
+	return fmt.Sprintf("no codec registered for content type %q", e.ContentType)
+}
+
+// Codec encode and decode the payload data for a content type, the codec package has binary ones
+type Codec interface {
+	ContentType() string
+	Marshal(v interface{}) ([]byte, error)
+	// Unmarshal decode into v, a pointer, ErrTypeRequired when v is a *interface{} and the encoding is not self-describing
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// EncodedData payload data already encoded with the message codec, sent as is.
+// Consumed messages have it as data when their codec needs the registered type to decode them
+type EncodedData []byte
+
+// JSONCodec the default codec
+type JSONCodec struct{}
+
+func (JSONCodec) ContentType() string {
+	return ContentTypeJSON
+}
+
+func (JSONCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (JSONCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+var codecs = struct {
+	sync.RWMutex
+	byContentType map[string]Codec
+}{byContentType: map[string]Codec{ContentTypeJSON: JSONCodec{}}}
+
+// RegisterCodec make the codec available to publish and consume messages of its content type
+func RegisterCodec(codec Codec) {
+	codecs.Lock()
+	defer codecs.Unlock()
+	codecs.byContentType[codec.ContentType()] = codec
+}
+
+// LookupCodec codec registered for the content type, JSON when it is empty
+func LookupCodec(contentType string) (Codec, error) {
+	if contentType == "" {
+		contentType = ContentTypeJSON
+	}
+
+	codecs.RLock()
+	defer codecs.RUnlock()
+	codec, ok := codecs.byContentType[contentType]
+	if !ok {
+		return nil, &UnsupportedContentTypeError{ContentType: contentType}
+	}
+	return codec, nil
+}
+
+// EncodePayload body of a message with the content type.
+// JSON bodies hold the whole payload, other codecs only encode the data and the entity id goes in EntityIdHeader
+func EncodePayload(contentType string, payload Payload) ([]byte, error) {
+	codec, err := LookupCodec(contentType)
+	if err != nil {
+		return nil, err
+	}
+	if codec.ContentType() == ContentTypeJSON {
+		return codec.Marshal(payload)
+	}
+	if encoded, ok := payload.Data.(EncodedData); ok {
+		return encoded, nil
+	}
+	return codec.Marshal(payload.Data)
+}
+
+// DecodePayload payload of a body with the content type, see EncodePayload.
+// The entity id of non JSON payloads is set by the caller from EntityIdHeader
+func DecodePayload(contentType string, body []byte) (Payload, error) {
+	codec, err := LookupCodec(contentType)
+	if err != nil {
+		return Payload{}, err
+	}
+
+	payload := Payload{}
+	if codec.ContentType() == ContentTypeJSON {
+		err := codec.Unmarshal(body, &payload)
+		return payload, err
+	}
+	if err := payload.DecodeData(contentType, body); err != nil {
+		return Payload{}, err
+	}
+	return payload, nil
+}
+
+// DecodeData set the data from its encoding with the codec of the content type.
+// The encoded data is kept for EventRegistry, it is EncodedData when the codec needs the registered type
+func (p *Payload) DecodeData(contentType string, data []byte) error {
+	codec, err := LookupCodec(contentType)
+	if err != nil {
+		return err
+	}
+
+	var decoded interface{}
+	if err := codec.Unmarshal(data, &decoded); err != nil {
+		if err != ErrTypeRequired {
+			return err
+		}
+		decoded = EncodedData(data)
+	}
+	p.Data = decoded
+	p.rawData = data
+	return nil
+}
@@ -0,0 +1,66 @@
+// Package codec binary payload codecs and compressors, register them with eventbusclient.RegisterCodec and RegisterCompressor
+package codec
+
+import (
+	"bytes"
+	"fmt"
+
+	eventbusclient "github.com/best-expendables/eventbus-client"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+)
+
+// Content types of the codecs
+const (
+	ContentTypeProtobuf = "application/x-protobuf"
+	ContentTypeMsgpack  = "application/msgpack"
+)
+
+// Protobuf encode proto.Message data, consumers need the type registered in an EventRegistry to decode it
+type Protobuf struct{}
+
+func (Protobuf) ContentType() string {
+	return ContentTypeProtobuf
+}
+
+func (Protobuf) Marshal(v interface{}) ([]byte, error) {
+	message, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("protobuf codec: %T is not a proto.Message", v)
+	}
+	return proto.Marshal(message)
+}
+
+func (Protobuf) Unmarshal(data []byte, v interface{}) error {
+	if _, ok := v.(*interface{}); ok {
+		return eventbusclient.ErrTypeRequired
+	}
+	message, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("protobuf codec: %T is not a proto.Message", v)
+	}
+	return proto.Unmarshal(data, message)
+}
+
+// Msgpack encode data with MessagePack, struct fields are named after their json tag
+type Msgpack struct{}
+
+func (Msgpack) ContentType() string {
+	return ContentTypeMsgpack
+}
+
+func (Msgpack) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := msgpack.NewEncoder(&buf)
+	encoder.SetCustomStructTag("json")
+	if err := encoder.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (Msgpack) Unmarshal(data []byte, v interface{}) error {
+	decoder := msgpack.NewDecoder(bytes.NewReader(data))
+	decoder.SetCustomStructTag("json")
+	return decoder.Decode(v)
+}
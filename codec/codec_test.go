@@ -0,0 +1,171 @@
+package codec
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	eventbusclient "github.com/best-expendables/eventbus-client"
+	"github.com/best-expendables/eventbus-client/helper"
+	"github.com/best-expendables/eventbus-client/producer_manager"
+	"github.com/best-expendables/eventbus-client/transport"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+const testQueue = "package_creation"
+
+type packageCreated struct {
+	PackageId string `json:"packageId" validate:"required"`
+	Weight    int64  `json:"weight"`
+}
+
+func init() {
+	eventbusclient.RegisterCodec(Protobuf{})
+	eventbusclient.RegisterCodec(Msgpack{})
+}
+
+func newMessage(contentType string, data interface{}) *eventbusclient.Message {
+	return &eventbusclient.Message{
+		Id:          "1",
+		RoutingKey:  testQueue,
+		ContentType: contentType,
+		Header: eventbusclient.Header{
+			Timestamp: time.Now(),
+			Publisher: "package",
+			EventName: "package_creation",
+		},
+		Payload: eventbusclient.Payload{EntityId: "entity", Data: data},
+	}
+}
+
+// roundTrip publish the message through a memory broker and rebuild it from the delivery
+func roundTrip(t *testing.T, msg *eventbusclient.Message) *eventbusclient.Message {
+	broker := transport.NewMemoryBroker()
+	p, err := producer_manager.NewProducerWithConfig(&eventbusclient.Config{
+		Transport: broker,
+		Topology:  &eventbusclient.Topology{Queues: []eventbusclient.Queue{{Name: testQueue}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	if err := p.Publish(context.Background(), msg); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, _ := broker.Dial("")
+	defer conn.Close()
+	ch, _ := conn.Channel()
+	deliveries, _ := ch.Consume(testQueue, "", true, false, false, false, nil)
+	select {
+	case delivery := <-deliveries:
+		if delivery.ContentType != msg.ContentType {
+			t.Errorf("expect content type %s, got %s", msg.ContentType, delivery.ContentType)
+		}
+		consumed, err := helper.GetMessageFromDelivery(delivery)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if consumed.ContentType != msg.ContentType || consumed.Payload.EntityId != "entity" {
+			t.Errorf("wrong consumed message: %+v", consumed)
+		}
+		if _, ok := consumed.Header.Extra[eventbusclient.EntityIdHeader]; ok {
+			t.Error("expect entity id header not to be an extra header")
+		}
+		return consumed
+	case <-time.After(time.Second):
+		t.Fatal("expect message in queue")
+	}
+	return nil
+}
+
+func TestProtobuf(t *testing.T) {
+	data, err := structpb.NewStruct(map[string]interface{}{"packageId": "P1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	consumed := roundTrip(t, newMessage(ContentTypeProtobuf, data))
+
+	if _, ok := consumed.Payload.Data.(eventbusclient.EncodedData); !ok {
+		t.Fatalf("expect encoded data without registered type, got %T", consumed.Payload.Data)
+	}
+
+	registry := eventbusclient.NewEventRegistry()
+	registry.Register("package_creation", &structpb.Struct{})
+	if err := registry.Decode(consumed); err != nil {
+		t.Fatal(err)
+	}
+	decoded, ok := consumed.TypedData().(*structpb.Struct)
+	if !ok || !proto.Equal(decoded, data) {
+		t.Errorf("expect decoded %v, got %v", data, consumed.TypedData())
+	}
+}
+
+func TestProtobuf_NotProtoMessage(t *testing.T) {
+	if _, err := (Protobuf{}).Marshal(packageCreated{}); err == nil {
+		t.Error("expect error marshaling a non proto.Message")
+	}
+}
+
+func TestMsgpack(t *testing.T) {
+	consumed := roundTrip(t, newMessage(ContentTypeMsgpack, packageCreated{PackageId: "P1", Weight: 9007199254740993}))
+
+	data, ok := consumed.Payload.Data.(map[string]interface{})
+	if !ok || data["packageId"] != "P1" {
+		t.Fatalf("expect data decoded by json field name, got %#v", consumed.Payload.Data)
+	}
+
+	registry := eventbusclient.NewEventRegistry()
+	registry.Register("package_creation", packageCreated{})
+	if err := registry.Decode(consumed); err != nil {
+		t.Fatal(err)
+	}
+	decoded := consumed.TypedData().(*packageCreated)
+	if decoded.PackageId != "P1" || decoded.Weight != 9007199254740993 {
+		t.Errorf("wrong decoded data: %+v", decoded)
+	}
+}
+
+func TestUnregisteredContentType(t *testing.T) {
+	_, err := eventbusclient.EncodePayload("application/xml", eventbusclient.Payload{Data: "data"})
+	if _, ok := err.(*eventbusclient.UnsupportedContentTypeError); !ok {
+		t.Errorf("expect unsupported content type error, got %v", err)
+	}
+}
+
+func TestZstd(t *testing.T) {
+	data := []byte(strings.Repeat(`{"packageId":"P1"}`, 100))
+	compressed, err := (Zstd{}).Compress(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(compressed) >= len(data) {
+		t.Errorf("expect compressed data, got %d bytes from %d", len(compressed), len(data))
+	}
+
+	eventbusclient.RegisterCompressor(Zstd{})
+	decompressed, err := eventbusclient.Decompress(ContentEncodingZstd, compressed)
+	if err != nil || string(decompressed) != string(data) {
+		t.Errorf("expect original data, got %v", err)
+	}
+}
+
+func TestZstd_MaxSize(t *testing.T) {
+	data := []byte(strings.Repeat("a", 1<<20))
+	compressed, _ := (Zstd{}).Compress(data)
+
+	if _, err := (Zstd{MaxSize: 2 << 20}).Decompress(compressed); err != nil {
+		t.Errorf("expect a body under the limit decompressed, got %v", err)
+	}
+
+	_, err := (Zstd{MaxSize: 4096}).Decompress(compressed)
+	sizeErr, ok := err.(*eventbusclient.DecompressedSizeError)
+	if !ok {
+		t.Fatalf("expect decompressed size error, got %v", err)
+	}
+	if sizeErr.ContentEncoding != ContentEncodingZstd || sizeErr.Limit != 4096 {
+		t.Errorf("unexpected error: %+v", sizeErr)
+	}
+}
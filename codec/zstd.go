@@ -0,0 +1,72 @@
+package codec
+
+import (
+	"sync"
+
+	eventbusclient "github.com/best-expendables/eventbus-client"
+	"github.com/klauspost/compress/zstd"
+)
+
+// ContentEncodingZstd content encoding of the zstd compressor
+const ContentEncodingZstd = "zstd"
+
+// encoder and decoders are safe for concurrent use through EncodeAll and DecodeAll
+var (
+	zstdEncoder, _ = zstd.NewWriter(nil)
+	// zstdDecoders one decoder per size limit
+	zstdDecoders sync.Map
+)
+
+// Zstd compress with Zstandard, register it with eventbusclient.RegisterCompressor.
+// MaxSize limits decompressed bodies, eventbusclient.DefaultMaxDecompressedSize when zero.
+// It bounds the frame window as well, keep it above the 8MB window of the encoder
+type Zstd struct {
+	MaxSize int64
+}
+
+func (Zstd) Encoding() string {
+	return ContentEncodingZstd
+}
+
+func (Zstd) Compress(data []byte) ([]byte, error) {
+	return zstdEncoder.EncodeAll(data, nil), nil
+}
+
+func (c Zstd) Decompress(data []byte) ([]byte, error) {
+	limit := c.limit()
+	decoder, err := zstdDecoder(limit)
+	if err != nil {
+		return nil, err
+	}
+	decompressed, err := decoder.DecodeAll(data, nil)
+	switch err {
+	case nil:
+		return decompressed, nil
+	case zstd.ErrDecoderSizeExceeded, zstd.ErrWindowSizeExceeded:
+		return nil, &eventbusclient.DecompressedSizeError{ContentEncoding: ContentEncodingZstd, Limit: limit}
+	default:
+		return nil, err
+	}
+}
+
+func (c Zstd) limit() int64 {
+	if c.MaxSize <= 0 {
+		return eventbusclient.DefaultMaxDecompressedSize
+	}
+	return c.MaxSize
+}
+
+func zstdDecoder(limit int64) (*zstd.Decoder, error) {
+	if decoder, ok := zstdDecoders.Load(limit); ok {
+		return decoder.(*zstd.Decoder), nil
+	}
+	decoder, err := zstd.NewReader(nil, zstd.WithDecoderMaxMemory(uint64(limit)))
+	if err != nil {
+		return nil, err
+	}
+	actual, loaded := zstdDecoders.LoadOrStore(limit, decoder)
+	if loaded {
+		decoder.Close()
+	}
+	return actual.(*zstd.Decoder), nil
+}
@@ -0,0 +1,120 @@
+package eventbusclient
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+)
+
+// ContentEncodingGzip content encoding of the built in gzip compressor
+const ContentEncodingGzip = "gzip"
+
+// DefaultMaxDecompressedSize limit of a decompressed body when the compressor has none
+const DefaultMaxDecompressedSize = 64 << 20
+
+// DecompressedSizeError the decompressed body is larger than the compressor limit, the delivery is rejected
+type DecompressedSizeError struct {
+	ContentEncoding string
+	Limit           int64
+}
+
+func (e *DecompressedSizeError) Error() string {
+	return fmt.Sprintf("body of content encoding %q decompresses to more than %d bytes", e.ContentEncoding, e.Limit)
+}
+
+// UnsupportedContentEncodingError no compressor is registered for the content encoding
+type UnsupportedContentEncodingError struct {
+	ContentEncoding string
+}
+
+func (e *UnsupportedContentEncodingError) Error() string {
+	return fmt.Sprintf("no compressor registered for content encoding %q", e.ContentEncoding)
+}
+
+// Compressor compress message bodies for a content encoding, the codec package has zstd
+type Compressor interface {
+	Encoding() string
+	Compress(data []byte) ([]byte, error)
+	Decompress(data []byte) ([]byte, error)
+}
+
+// GzipCompressor compress with gzip.
+// MaxSize limits decompressed bodies, DefaultMaxDecompressedSize when zero
+type GzipCompressor struct {
+	MaxSize int64
+}
+
+func (GzipCompressor) Encoding() string {
+	return ContentEncodingGzip
+}
+
+func (GzipCompressor) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	if _, err := writer.Write(data); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c GzipCompressor) Decompress(data []byte) ([]byte, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	limit := c.MaxSize
+	if limit <= 0 {
+		limit = DefaultMaxDecompressedSize
+	}
+	// one byte over the limit tells a body of exactly the limit from a larger one
+	decompressed, err := ioutil.ReadAll(io.LimitReader(reader, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(decompressed)) > limit {
+		return nil, &DecompressedSizeError{ContentEncoding: ContentEncodingGzip, Limit: limit}
+	}
+	return decompressed, nil
+}
+
+var compressors = struct {
+	sync.RWMutex
+	byEncoding map[string]Compressor
+}{byEncoding: map[string]Compressor{ContentEncodingGzip: GzipCompressor{}}}
+
+// RegisterCompressor make the compressor available to publish and consume messages of its content encoding
+func RegisterCompressor(compressor Compressor) {
+	compressors.Lock()
+	defer compressors.Unlock()
+	compressors.byEncoding[compressor.Encoding()] = compressor
+}
+
+// LookupCompressor compressor registered for the content encoding
+func LookupCompressor(encoding string) (Compressor, error) {
+	compressors.RLock()
+	defer compressors.RUnlock()
+	compressor, ok := compressors.byEncoding[encoding]
+	if !ok {
+		return nil, &UnsupportedContentEncodingError{ContentEncoding: encoding}
+	}
+	return compressor, nil
+}
+
+// Decompress body of the content encoding, returned as is when the encoding is empty
+func Decompress(encoding string, body []byte) ([]byte, error) {
+	if encoding == "" {
+		return body, nil
+	}
+	compressor, err := LookupCompressor(encoding)
+	if err != nil {
+		return nil, err
+	}
+	return compressor.Decompress(body)
+}
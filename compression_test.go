@@ -0,0 +1,28 @@
+package eventbusclient
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestGzipCompressor_MaxSize(t *testing.T) {
+	data := bytes.Repeat([]byte("a"), 4096)
+	compressed, err := (GzipCompressor{}).Compress(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decompressed, err := (GzipCompressor{MaxSize: 4096}).Decompress(compressed)
+	if err != nil || !bytes.Equal(decompressed, data) {
+		t.Errorf("expect a body of exactly the limit decompressed, got %v", err)
+	}
+
+	_, err = (GzipCompressor{MaxSize: 4095}).Decompress(compressed)
+	sizeErr, ok := err.(*DecompressedSizeError)
+	if !ok {
+		t.Fatalf("expect decompressed size error, got %v", err)
+	}
+	if sizeErr.ContentEncoding != ContentEncodingGzip || sizeErr.Limit != 4095 {
+		t.Errorf("unexpected error: %+v", sizeErr)
+	}
+}
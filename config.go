@@ -2,8 +2,10 @@ package eventbusclient
 
 import (
 	"fmt"
+	"github.com/best-expendables/eventbus-client/transport"
 	"github.com/kelseyhightower/envconfig"
 	"html/template"
+	"time"
 )
 
 //Config Eventbus config
@@ -13,6 +15,23 @@ type Config struct {
 	Username     string `envconfig:"EVENTBUS_USERNAME" required:"true"`
 	Password     string `envconfig:"EVENTBUS_PASSWORD" required:"true"`
 	PrefectCount int    `envconfig:"EVENTBUS_PREFECT_COUNT" required:"false" default:"50"`
+
+	PublishMaxAttempts      int           `envconfig:"EVENTBUS_PUBLISH_MAX_ATTEMPTS" required:"false" default:"5"`
+	PublishRetryInterval    time.Duration `envconfig:"EVENTBUS_PUBLISH_RETRY_INTERVAL" required:"false" default:"100ms"`
+	PublishRetryMaxInterval time.Duration `envconfig:"EVENTBUS_PUBLISH_RETRY_MAX_INTERVAL" required:"false" default:"5s"`
+	// PublisherChannelPoolSize number of confirm mode channels the producer publishes on concurrently
+	PublisherChannelPoolSize int `envconfig:"EVENTBUS_PUBLISHER_CHANNEL_POOL_SIZE" required:"false" default:"4"`
+	// Compression content encoding of published bodies, gzip or a registered compressor, disabled when empty
+	Compression string `envconfig:"EVENTBUS_COMPRESSION" required:"false"`
+	// CompressionThreshold bodies smaller than this number of bytes are sent uncompressed
+	CompressionThreshold int `envconfig:"EVENTBUS_COMPRESSION_THRESHOLD" required:"false" default:"1024"`
+
+	// Topology declared on every (re)connect, nothing is declared when nil
+	Topology *Topology `ignored:"true"`
+	// Transport used to reach the broker, transport.AMQP when nil
+	Transport transport.Transport `ignored:"true"`
+	// Metrics receive the producer and connection metrics, discarded when nil
+	Metrics MetricsSink `ignored:"true"`
 }
 
 // GetURL from config, build connection string
@@ -20,6 +39,22 @@ func (c Config) GetURL() string {
 	return fmt.Sprintf("amqp://%s:%s@%s:%s/", c.Username, template.URLQueryEscaper(c.Password), c.Host, c.Port)
 }
 
+// GetTransport transport configured, falls back to transport.AMQP
+func (c Config) GetTransport() transport.Transport {
+	if c.Transport == nil {
+		return transport.AMQP
+	}
+	return c.Transport
+}
+
+// GetMetrics metrics sink configured, falls back to NopMetrics
+func (c Config) GetMetrics() MetricsSink {
+	if c.Metrics == nil {
+		return NopMetrics{}
+	}
+	return c.Metrics
+}
+
 // GetAppConfigFromEnv Read system environment to get config
 func GetAppConfigFromEnv() Config {
 	var conf Config
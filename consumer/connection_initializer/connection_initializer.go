@@ -7,6 +7,7 @@ import (
 	"time"
 
 	eventbusclient "github.com/best-expendables/eventbus-client"
+	"github.com/best-expendables/eventbus-client/transport"
 	"github.com/best-expendables/logger"
 	"github.com/streadway/amqp"
 )
@@ -23,17 +24,18 @@ var ConnectionManagerDisconnected = errors.New("connection manager disconnected"
 type ConnectionInitializer interface {
 	Connect() error
 	ShutDown() error
-	GetAMQPChannel() (*amqp.Channel, error)
+	GetAMQPChannel() (transport.Channel, error)
 	ReconnectWithConnectionError()
 	ReconnectSuccessfulNotifierChannel() <-chan bool
+	Status() string
 }
 
 type connectionInitializer struct {
 	conf   *eventbusclient.Config
 	locker sync.Mutex
 
-	conn                        *amqp.Connection
-	channel                     *amqp.Channel
+	conn                        transport.Connection
+	channel                     transport.Channel
 	status                      string
 	reconnectSuccessfulNotifier chan bool
 	doneChan                    chan interface{}
@@ -52,12 +54,12 @@ func NewConnectionInitializer(conf *eventbusclient.Config) ConnectionInitializer
 }
 
 func (cm *connectionInitializer) Connect() error {
-	if cm.status == ConnectionManagerStatusConnected {
+	if cm.Status() == ConnectionManagerStatusConnected {
 		return nil
 	}
 	var err error
 
-	cm.conn, err = amqp.Dial(cm.conf.GetURL())
+	cm.conn, err = cm.conf.GetTransport().Dial(cm.conf.GetURL())
 	if err != nil {
 		return fmt.Errorf("dial: %s", err)
 	}
@@ -71,21 +73,25 @@ func (cm *connectionInitializer) Connect() error {
 	if err != nil {
 		return fmt.Errorf("set prefetch count fail: %s", err)
 	}
-	cm.status = ConnectionManagerStatusConnected
+
+	if err := cm.conf.Topology.Declare(cm.channel); err != nil {
+		return err
+	}
+	cm.setStatus(ConnectionManagerStatusConnected)
 	return nil
 }
 
 func (cm *connectionInitializer) ShutDown() error {
 	close(cm.doneChan)
-	if cm.status == ConnectionManagerStatusConnected {
-		if err := cm.channel.Cancel("", true); err != nil {
-			return fmt.Errorf("consumer_manager cancel failed: %s", err)
+	if cm.Status() == ConnectionManagerStatusConnected {
+		if err := cm.channel.Close(); err != nil && err != amqp.ErrClosed {
+			return fmt.Errorf("AMQP channel close error: %s", err)
 		}
 		if err := cm.conn.Close(); err != nil {
 			return fmt.Errorf("AMQP connection close error: %s", err)
 		}
 	}
-	cm.status = ConnectionManagerStatusShutdown
+	cm.setStatus(ConnectionManagerStatusShutdown)
 	cm.channel = nil
 	cm.conn = nil
 	return nil
@@ -100,15 +106,16 @@ func (cm *connectionInitializer) ReconnectWithConnectionError() {
 			case closeErr := <-cm.conn.NotifyClose(make(chan *amqp.Error)):
 				logger.Errorf("connection closed by ", closeErr)
 
-				if cm.status == ConnectionManagerStatusShutdown {
+				if cm.Status() == ConnectionManagerStatusShutdown {
 					return
 				}
-				cm.status = ConnectionManagerStatusDisconnected
+				cm.setStatus(ConnectionManagerStatusDisconnected)
 				for {
 					logger.Info("reconnecting")
 					err := cm.Connect()
 					if err == nil {
-						cm.status = ConnectionManagerStatusConnected
+						cm.setStatus(ConnectionManagerStatusConnected)
+						cm.conf.GetMetrics().Reconnected(eventbusclient.ComponentConsumer)
 						cm.reconnectSuccessfulNotifier <- true
 						logger.Info("reconnected")
 						break
@@ -126,8 +133,21 @@ func (cm *connectionInitializer) ReconnectSuccessfulNotifierChannel() <-chan boo
 	return cm.reconnectSuccessfulNotifier
 }
 
-func (cm *connectionInitializer) GetAMQPChannel() (*amqp.Channel, error) {
-	if cm.status != ConnectionManagerStatusConnected {
+// Status one of the ConnectionManagerStatus constants
+func (cm *connectionInitializer) Status() string {
+	cm.locker.Lock()
+	defer cm.locker.Unlock()
+	return cm.status
+}
+
+func (cm *connectionInitializer) setStatus(status string) {
+	cm.locker.Lock()
+	defer cm.locker.Unlock()
+	cm.status = status
+}
+
+func (cm *connectionInitializer) GetAMQPChannel() (transport.Channel, error) {
+	if cm.Status() != ConnectionManagerStatusConnected {
 		return nil, ConnectionManagerDisconnected
 	}
 	return cm.channel, nil
@@ -0,0 +1,233 @@
+package consumer_manager
+
+import (
+	"sync"
+	"time"
+
+	"github.com/best-expendables/logger"
+)
+
+// BreakerState state of the circuit breaker of a queue
+type BreakerState string
+
+const (
+	// BreakerClosed messages are consumed
+	BreakerClosed BreakerState = "closed"
+	// BreakerOpen no message is taken from the queue, the prefetched ones stay unacked on the broker
+	BreakerOpen BreakerState = "open"
+	// BreakerHalfOpen a few trial messages are consumed to decide whether to close or open again
+	BreakerHalfOpen BreakerState = "half-open"
+)
+
+const breakerPollInterval = 50 * time.Millisecond
+
+// CircuitBreakerConfig when the breaker of a queue opens, zero values take the defaults
+type CircuitBreakerConfig struct {
+	// Window number of last messages whose failure ratio is watched, 20 by default
+	Window int
+	// MinMessages messages observed before the breaker can open, the window size by default
+	MinMessages int
+	// FailureRatio ratio of messages failing with an error that opens the breaker, 0.5 by default
+	FailureRatio float64
+	// OpenDuration time the breaker stays open before trial messages, 30s by default
+	OpenDuration time.Duration
+	// HalfOpenTrials trial messages that must all succeed to close the breaker, 1 by default
+	HalfOpenTrials int
+	// OnStateChange called on every state change of the queue breaker
+	OnStateChange func(queue string, from, to BreakerState)
+}
+
+func (c CircuitBreakerConfig) withDefaults() CircuitBreakerConfig {
+	if c.Window <= 0 {
+		c.Window = 20
+	}
+	if c.MinMessages <= 0 || c.MinMessages > c.Window {
+		c.MinMessages = c.Window
+	}
+	if c.FailureRatio <= 0 {
+		c.FailureRatio = 0.5
+	}
+	if c.OpenDuration <= 0 {
+		c.OpenDuration = 30 * time.Second
+	}
+	if c.HalfOpenTrials <= 0 {
+		c.HalfOpenTrials = 1
+	}
+	return c
+}
+
+type circuitBreaker struct {
+	queue  string
+	config CircuitBreakerConfig
+
+	mu       sync.Mutex
+	state    BreakerState
+	results  []bool // failed outcomes of the last messages, a ring of config.Window
+	next     int
+	observed int
+	failures int
+	openedAt time.Time
+	// generation changes with the state, permits of an older one are ignored
+	generation int
+	// trials taken and succeeded since half-open
+	trials    int
+	succeeded int
+	// transitions waiting for OnStateChange, called once the lock is released
+	transitions [][2]BreakerState
+}
+
+func newCircuitBreaker(queue string, config CircuitBreakerConfig) *circuitBreaker {
+	config = config.withDefaults()
+	return &circuitBreaker{
+		queue:   queue,
+		config:  config,
+		state:   BreakerClosed,
+		results: make([]bool, config.Window),
+	}
+}
+
+// breakerPermit lets one message through, its outcome counts for the state it was acquired in
+type breakerPermit struct {
+	generation int
+}
+
+// acquire wait until the breaker lets a message through, false when done or stop is closed first
+func (b *circuitBreaker) acquire(done, stop <-chan interface{}) (breakerPermit, bool) {
+	for {
+		permit, wait := b.tryAcquire()
+		if wait == 0 {
+			return permit, true
+		}
+		select {
+		case <-done:
+			return breakerPermit{}, false
+		case <-stop:
+			return breakerPermit{}, false
+		case <-time.After(wait):
+		}
+	}
+}
+
+// tryAcquire take a permit, or return how long to wait before trying again
+func (b *circuitBreaker) tryAcquire() (breakerPermit, time.Duration) {
+	defer b.notify()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == BreakerOpen {
+		remaining := b.config.OpenDuration - time.Since(b.openedAt)
+		if remaining > 0 {
+			return breakerPermit{}, remaining
+		}
+		b.setState(BreakerHalfOpen)
+	}
+	if b.state == BreakerHalfOpen {
+		if b.trials >= b.config.HalfOpenTrials {
+			return breakerPermit{}, breakerPollInterval
+		}
+		b.trials++
+	}
+	return breakerPermit{generation: b.generation}, 0
+}
+
+// release a permit without outcome, the worker stopped or the delivery never reached the handler
+func (b *circuitBreaker) release(permit breakerPermit) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if permit.generation == b.generation && b.state == BreakerHalfOpen && b.trials > b.succeeded {
+		b.trials--
+	}
+}
+
+// record the handler outcome of a message consumed with the permit
+func (b *circuitBreaker) record(permit breakerPermit, failed bool) {
+	if b == nil {
+		return
+	}
+	defer b.notify()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// acquired before the last state change, e.g. a message started while closed finishing once half-open
+	if permit.generation != b.generation {
+		return
+	}
+	switch b.state {
+	case BreakerHalfOpen:
+		if failed {
+			b.open()
+			return
+		}
+		b.succeeded++
+		if b.succeeded >= b.config.HalfOpenTrials {
+			b.reset()
+			b.setState(BreakerClosed)
+		}
+	case BreakerClosed:
+		if b.results[b.next] {
+			b.failures--
+		}
+		b.results[b.next] = failed
+		if failed {
+			b.failures++
+		}
+		b.next = (b.next + 1) % len(b.results)
+		if b.observed < len(b.results) {
+			b.observed++
+		}
+		if b.observed >= b.config.MinMessages && float64(b.failures)/float64(b.observed) >= b.config.FailureRatio {
+			b.open()
+		}
+	}
+}
+
+func (b *circuitBreaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+func (b *circuitBreaker) open() {
+	b.reset()
+	b.openedAt = time.Now()
+	b.setState(BreakerOpen)
+}
+
+func (b *circuitBreaker) reset() {
+	for i := range b.results {
+		b.results[i] = false
+	}
+	b.next, b.observed, b.failures = 0, 0, 0
+	b.trials, b.succeeded = 0, 0
+}
+
+func (b *circuitBreaker) setState(state BreakerState) {
+	from := b.state
+	if from == state {
+		return
+	}
+	b.state = state
+	b.generation++
+	if state == BreakerOpen {
+		logger.Errorf("circuit breaker of queue %s opened, consumption paused for %s", b.queue, b.config.OpenDuration)
+	} else {
+		logger.Infof("circuit breaker of queue %s is %s", b.queue, state)
+	}
+	if b.config.OnStateChange != nil {
+		b.transitions = append(b.transitions, [2]BreakerState{from, state})
+	}
+}
+
+func (b *circuitBreaker) notify() {
+	b.mu.Lock()
+	transitions := b.transitions
+	b.transitions = nil
+	b.mu.Unlock()
+
+	for _, transition := range transitions {
+		b.config.OnStateChange(b.queue, transition[0], transition[1])
+	}
+}
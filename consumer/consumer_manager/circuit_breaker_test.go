@@ -0,0 +1,105 @@
+package consumer_manager
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	var transitions []BreakerState
+	b := newCircuitBreaker("queue", CircuitBreakerConfig{
+		Window:         4,
+		FailureRatio:   0.5,
+		OpenDuration:   20 * time.Millisecond,
+		HalfOpenTrials: 2,
+		OnStateChange: func(queue string, from, to BreakerState) {
+			transitions = append(transitions, to)
+		},
+	})
+	done := make(chan interface{})
+
+	for _, failed := range []bool{true, false, false, true} {
+		permit, ok := b.acquire(done, nil)
+		if !ok {
+			t.Fatal("expect closed breaker to let messages through")
+		}
+		b.record(permit, failed)
+	}
+	if b.State() != BreakerOpen {
+		t.Fatalf("expect breaker open at the failure ratio, got %s", b.State())
+	}
+	if _, wait := b.tryAcquire(); wait == 0 {
+		t.Fatal("expect open breaker to hold messages")
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	first, _ := b.acquire(done, nil)
+	second, _ := b.acquire(done, nil)
+	if _, wait := b.tryAcquire(); b.State() != BreakerHalfOpen || wait == 0 {
+		t.Fatalf("expect half-open breaker to allow only its trials, got %s", b.State())
+	}
+	b.record(first, false)
+	b.record(second, true)
+	if b.State() != BreakerOpen {
+		t.Fatalf("expect failed trial to open the breaker again, got %s", b.State())
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	first, _ = b.acquire(done, nil)
+	second, _ = b.acquire(done, nil)
+	b.record(first, false)
+	b.record(second, false)
+	if b.State() != BreakerClosed {
+		t.Fatalf("expect succeeded trials to close the breaker, got %s", b.State())
+	}
+
+	expected := []BreakerState{BreakerOpen, BreakerHalfOpen, BreakerOpen, BreakerHalfOpen, BreakerClosed}
+	if len(transitions) != len(expected) {
+		t.Fatalf("expect transitions %v, got %v", expected, transitions)
+	}
+	for i := range expected {
+		if transitions[i] != expected[i] {
+			t.Errorf("expect transitions %v, got %v", expected, transitions)
+			break
+		}
+	}
+
+	close(done)
+	for i := 0; i < 4; i++ {
+		permit, _ := b.tryAcquire()
+		b.record(permit, true)
+	}
+	if _, ok := b.acquire(done, nil); ok {
+		t.Error("expect acquire to give up once done")
+	}
+}
+
+func TestCircuitBreaker_StalePermit(t *testing.T) {
+	b := newCircuitBreaker("queue", CircuitBreakerConfig{Window: 2, OpenDuration: 10 * time.Millisecond})
+
+	// a slow message started while closed, the breaker opens and turns half-open meanwhile
+	slow, _ := b.tryAcquire()
+	for i := 0; i < 2; i++ {
+		permit, _ := b.tryAcquire()
+		b.record(permit, true)
+	}
+	time.Sleep(10 * time.Millisecond)
+	trial, wait := b.tryAcquire()
+	if wait != 0 || b.State() != BreakerHalfOpen {
+		t.Fatalf("expect a half-open trial, got %s", b.State())
+	}
+
+	b.record(slow, false)
+	if b.State() != BreakerHalfOpen {
+		t.Fatalf("expect the slow message not counted as a trial, got %s", b.State())
+	}
+	b.release(slow)
+	if _, wait := b.tryAcquire(); wait == 0 {
+		t.Fatal("expect the slow message release not to free a trial")
+	}
+
+	b.release(trial)
+	if _, wait := b.tryAcquire(); wait != 0 {
+		t.Fatal("expect the released trial to be taken again")
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"github.com/best-expendables/logger"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	eventbusclient "github.com/best-expendables/eventbus-client"
@@ -18,27 +19,62 @@ import (
 
 type Manager interface {
 	AssignConsumerToQueue(queueName string, consumer base_consumer.Consumer, replication int)
+	SetCircuitBreaker(queueName string, config CircuitBreakerConfig)
+	SetRateLimit(queueName string, config RateLimitConfig)
 	StartConsuming(queueNames ...string) error
-	ShutDown()
+	ScaleQueue(queueName string, workers int) error
+	AutoscaleQueue(queueName string, config AutoscaleConfig) error
+	Workers(queueName string) int
+	Status() map[string]QueueStatus
+	ShutDown(ctx context.Context) error
 }
 
 var ErrInvalidJson = errors.New("payload is not a valid json data")
 
+// ShutdownError the shutdown deadline passed before every in flight message was acked
+type ShutdownError struct {
+	// Abandoned messages still being consumed, the broker redelivers them
+	Abandoned int
+	Err       error
+}
+
+func (e *ShutdownError) Error() string {
+	return fmt.Sprintf("shutdown abandoned %d in flight message(s): %s", e.Abandoned, e.Err)
+}
+
+func (e *ShutdownError) Unwrap() error {
+	return e.Err
+}
+
 type consumerManager struct {
 	deliveryChannelManager delivery_channel_manager.DeliveryChannelManager
 	wg                     sync.WaitGroup
 	doneChan               chan interface{}
 	consumerByQueue        map[string]base_consumer.Consumer
 	consumerByQueueCount   map[string]int
+	breakerByQueue         map[string]*circuitBreaker
+	limiterByQueue         map[string]*queueRateLimiter
+	workersByQueue         map[string]*queueWorkers
+	locker                 sync.Mutex
+	inFlight               int32
+	// ctx done on shutdown, ends the rate limit waits
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
 func NewConsumerManager(deliveryChannelManager delivery_channel_manager.DeliveryChannelManager) Manager {
+	ctx, cancel := context.WithCancel(context.Background())
 	return &consumerManager{
 		deliveryChannelManager: deliveryChannelManager,
 		wg:                     sync.WaitGroup{},
 		doneChan:               make(chan interface{}),
 		consumerByQueue:        make(map[string]base_consumer.Consumer),
 		consumerByQueueCount:   map[string]int{},
+		breakerByQueue:         map[string]*circuitBreaker{},
+		limiterByQueue:         map[string]*queueRateLimiter{},
+		workersByQueue:         map[string]*queueWorkers{},
+		ctx:                    ctx,
+		cancel:                 cancel,
 	}
 }
 
@@ -47,6 +83,16 @@ func (c *consumerManager) AssignConsumerToQueue(queueName string, consumer base_
 	c.consumerByQueueCount[queueName] = replication
 }
 
+// SetCircuitBreaker stop taking messages from the queue while too many of them fail, set it before StartConsuming
+func (c *consumerManager) SetCircuitBreaker(queueName string, config CircuitBreakerConfig) {
+	c.breakerByQueue[queueName] = newCircuitBreaker(queueName, config)
+}
+
+// SetRateLimit limit the messages of the queue given to its consumer per second, set it before StartConsuming
+func (c *consumerManager) SetRateLimit(queueName string, config RateLimitConfig) {
+	c.limiterByQueue[queueName] = newQueueRateLimiter(queueName, config)
+}
+
 func (c *consumerManager) StartConsuming(queueNames ...string) error {
 	consumerQueues := queueNames
 	if len(consumerQueues) == 0 {
@@ -74,45 +120,129 @@ func (c *consumerManager) startConsumingQueue(queueName string) error {
 		return fmt.Errorf("there is no consumer_manager for queue: %s", queueName)
 	}
 
+	c.locker.Lock()
+	defer c.locker.Unlock()
+	if _, started := c.workersByQueue[queueName]; started {
+		return nil
+	}
+	workers := &queueWorkers{}
+	c.workersByQueue[queueName] = workers
 	for i := 0; i < c.consumerByQueueCount[queueName]; i++ {
-		go func() {
-			deliveryChan := c.deliveryChannelManager.GetDeliveryChan(queueName)
-			for {
-				select {
-				case <-c.doneChan:
-					return
-				case delivery := <-deliveryChan:
-					msg := c.processDelivery(queueName, delivery, consumerForQueue)
-					for {
-						err := c.AckDelivery(delivery, msg.Status)
-						if err == nil || err != amqp.ErrClosed {
-							break
-						}
-						c.deliveryChannelManager.NotifiedConnectionError()
-						time.Sleep(time.Second * 2)
-					}
-				}
-			}
-		}()
+		c.addWorker(queueName, consumerForQueue, workers)
 	}
 	logger.Infof("Start consumer on queue: %s", queueName)
 	return nil
 }
 
-func (c *consumerManager) processDelivery(queueName string, d amqp.Delivery, consumer base_consumer.Consumer) *eventbusclient.Message {
-	if !json.Valid(d.Body) {
-		return &eventbusclient.Message{
-			Status: eventbusclient.MessageStatusAck,
-			Error:  ErrInvalidJson,
+// work consume deliveries of the queue until shutdown or until stop is closed, the current message is finished first
+func (c *consumerManager) work(queueName string, consumer base_consumer.Consumer, stop <-chan interface{}, workers *queueWorkers) {
+	defer c.wg.Done()
+	breaker := c.breakerByQueue[queueName]
+	deliveryChan := c.deliveryChannelManager.GetDeliveryChan(queueName)
+	for {
+		// an open breaker leaves the deliveries with the broker
+		var permit breakerPermit
+		if breaker != nil {
+			var ok bool
+			if permit, ok = breaker.acquire(c.doneChan, stop); !ok {
+				return
+			}
+		}
+		select {
+		case <-c.doneChan:
+			breaker.release(permit)
+			return
+		case <-stop:
+			breaker.release(permit)
+			return
+		case delivery := <-deliveryChan:
+			// left unacked, the broker requeues it once the channel is closed
+			if c.isShuttingDown() {
+				breaker.release(permit)
+				return
+			}
+			atomic.AddInt32(&c.inFlight, 1)
+			workers.begin()
+			msg, handled, busy := c.processDelivery(queueName, delivery, consumer)
+			if msg == nil {
+				// shut down while waiting for the rate limit, left unacked
+				breaker.release(permit)
+				workers.end(false)
+				atomic.AddInt32(&c.inFlight, -1)
+				return
+			}
+			workers.observe(busy)
+			// undecodable deliveries say nothing about the handler health
+			if handled {
+				breaker.record(permit, msg.Error != nil)
+			} else {
+				breaker.release(permit)
+			}
+			// the delivery belongs to a closed channel, the broker redelivers it once reconnected
+			err := c.AckDelivery(delivery, msg.Status)
+			if err == amqp.ErrClosed && !c.isShuttingDown() {
+				c.deliveryChannelManager.NotifiedConnectionError()
+			}
+			workers.end(err == nil)
+			atomic.AddInt32(&c.inFlight, -1)
 		}
 	}
+}
 
-	msg := helper.GetMessageFromDelivery(d)
-	if msg.Error != nil {
-		return msg
+// processDelivery consume the delivery, nil when shutting down before it is consumed.
+// handled is false for undecodable deliveries, the consumer never got them.
+// busy is the time spent consuming, the rate limit wait is not part of it
+func (c *consumerManager) processDelivery(queueName string, d amqp.Delivery, consumer base_consumer.Consumer) (msg *eventbusclient.Message, handled bool, busy time.Duration) {
+	d, err := helper.DecompressDelivery(d)
+	if err != nil {
+		return undecodable(queueName, d, err), false, 0
 	}
+
+	if helper.DeliveryContentType(d) == eventbusclient.ContentTypeJSON && !json.Valid(d.Body) {
+		return undecodable(queueName, d, ErrInvalidJson), false, 0
+	}
+
+	msg, err = helper.GetMessageFromDelivery(d)
+	if err != nil {
+		return undecodable(queueName, d, err), false, 0
+	}
+	msg.Queue = queueName
+	if limiter := c.limiterByQueue[queueName]; limiter != nil {
+		if err := limiter.wait(c.ctx, msg.Header.EventName); err != nil {
+			return nil, false, 0
+		}
+	}
+	start := time.Now()
 	c.processMessage(consumer, helper.ContextFromMessage(msg), msg)
-	return msg
+	return msg, true, time.Since(start)
+}
+
+// undecodable log a delivery that can not be decoded. Unsupported content types and encodings are rejected,
+// so the broker dead-letter exchange of the queue keeps them until a codec is registered,
+// bodies decompressing over the compressor limit are rejected too, other ones are acked
+func undecodable(queueName string, d amqp.Delivery, err error) *eventbusclient.Message {
+	status := eventbusclient.MessageStatusAck
+	switch err.(type) {
+	case *eventbusclient.UnsupportedContentTypeError, *eventbusclient.UnsupportedContentEncodingError,
+		*eventbusclient.DecompressedSizeError:
+		status = eventbusclient.MessageStatusReject
+	}
+	helper.LoggerFromCtx(context.Background()).WithFields(logger.Fields{
+		"message_id":       d.MessageId,
+		"exchange":         d.Exchange,
+		"routing_key":      d.RoutingKey,
+		"queue":            queueName,
+		"content_type":     d.ContentType,
+		"content_encoding": d.ContentEncoding,
+		"publisher":        d.Headers["publisher"],
+		"event_name":       d.Headers["eventName"],
+		"status":           status,
+	}).Errorf("MessageUndecodable: %s", err)
+
+	return &eventbusclient.Message{
+		Status: status,
+		Error:  err,
+	}
 }
 
 func (c *consumerManager) AckDelivery(delivery amqp.Delivery, status string) error {
@@ -128,15 +258,38 @@ func (c *consumerManager) AckDelivery(delivery amqp.Delivery, status string) err
 	}
 }
 
-func (c *consumerManager) ShutDown() {
+// ShutDown stop taking deliveries and wait for the in flight ones to be consumed and acked, until ctx is done
+func (c *consumerManager) ShutDown(ctx context.Context) error {
+	// no worker is added once the wait starts
+	c.locker.Lock()
 	close(c.doneChan)
+	c.locker.Unlock()
+	c.cancel()
+
+	drained := make(chan struct{})
+	go func() {
+		c.wg.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		return &ShutdownError{Abandoned: int(atomic.LoadInt32(&c.inFlight)), Err: ctx.Err()}
+	}
 }
 
-func (c *consumerManager) processMessage(consumer base_consumer.Consumer, ctx context.Context, message *eventbusclient.Message) {
-	if len(consumer.Middlewares()) == 0 {
-		consumer.Consume(ctx, message)
+func (c *consumerManager) isShuttingDown() bool {
+	select {
+	case <-c.doneChan:
+		return true
+	default:
+		return false
 	}
+}
 
+func (c *consumerManager) processMessage(consumer base_consumer.Consumer, ctx context.Context, message *eventbusclient.Message) {
 	h := consumer.Consume
 	for i := len(consumer.Middlewares()) - 1; i >= 0; i-- {
 		h = consumer.Middlewares()[i](h)
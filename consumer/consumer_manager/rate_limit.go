@@ -0,0 +1,143 @@
+package consumer_manager
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/best-expendables/logger"
+	"github.com/go-redis/redis/v8"
+	"golang.org/x/time/rate"
+)
+
+// RateLimitKeyPrefix prefix of the redis keys of shared rate limits
+const RateLimitKeyPrefix = "eventbus:ratelimit:"
+
+// RateLimit messages consumed per second
+type RateLimit struct {
+	// Rate messages per second, no limit when zero
+	Rate float64
+	// Burst messages consumed at once after idling, 1 by default
+	Burst int
+	// Redis share the limit between the replicas of the service, the limit is local to the process when nil
+	Redis *redis.Client
+	// Key redis key of a shared limit, the queue and event names by default
+	Key string
+}
+
+// RateLimitConfig rate limits of a queue, a message waits for the queue limit then for the one of its event
+type RateLimitConfig struct {
+	Limit  RateLimit
+	Events map[string]RateLimit
+}
+
+type rateLimiter interface {
+	Wait(ctx context.Context) error
+}
+
+type queueRateLimiter struct {
+	queue  rateLimiter
+	events map[string]rateLimiter
+}
+
+func newQueueRateLimiter(queueName string, config RateLimitConfig) *queueRateLimiter {
+	limiter := &queueRateLimiter{
+		queue:  newRateLimiter(queueName, config.Limit),
+		events: map[string]rateLimiter{},
+	}
+	for eventName, limit := range config.Events {
+		limiter.events[eventName] = newRateLimiter(queueName+":"+eventName, limit)
+	}
+	return limiter
+}
+
+// wait until the message of the event can be consumed, an error when ctx is done first
+func (l *queueRateLimiter) wait(ctx context.Context, eventName string) error {
+	if l.queue != nil {
+		if err := l.queue.Wait(ctx); err != nil {
+			return err
+		}
+	}
+	if limiter := l.events[eventName]; limiter != nil {
+		return limiter.Wait(ctx)
+	}
+	return nil
+}
+
+func newRateLimiter(name string, limit RateLimit) rateLimiter {
+	if limit.Rate <= 0 {
+		return nil
+	}
+	if limit.Burst <= 0 {
+		limit.Burst = 1
+	}
+	local := rate.NewLimiter(rate.Limit(limit.Rate), limit.Burst)
+	if limit.Redis == nil {
+		return local
+	}
+	if limit.Key == "" {
+		limit.Key = RateLimitKeyPrefix + name
+	}
+	return &redisRateLimiter{client: limit.Redis, limit: limit, fallback: local}
+}
+
+// gcraScript generic cell rate algorithm, KEYS[1] holds the theoretical arrival time in microseconds.
+// It takes a slot and returns 0, or returns the microseconds to wait for one. The redis clock is shared by every replica.
+// Writing after the non deterministic TIME needs script effects replication, the default from Redis 5, so Redis 3.2 at least
+var gcraScript = redis.NewScript(`
+redis.replicate_commands()
+local now = redis.call('TIME')
+now = tonumber(now[1]) * 1000000 + tonumber(now[2])
+local interval = 1000000 / tonumber(ARGV[1])
+local tolerance = (tonumber(ARGV[2]) - 1) * interval
+
+local tat = tonumber(redis.call('GET', KEYS[1])) or now
+if tat < now then
+	tat = now
+end
+local wait = tat - now - tolerance
+if wait > 0 then
+	return math.ceil(wait)
+end
+tat = tat + interval
+redis.call('SET', KEYS[1], string.format('%.0f', tat), 'PX', math.ceil((tat - now) / 1000) + 1)
+return 0
+`)
+
+// redisRateLimiter limit shared through redis, the local limiter takes over while redis fails
+type redisRateLimiter struct {
+	client   *redis.Client
+	limit    RateLimit
+	fallback *rate.Limiter
+	// fallingBack 1 while redis fails, so the switches are logged rather than every failed call
+	fallingBack int32
+}
+
+func (l *redisRateLimiter) Wait(ctx context.Context) error {
+	for {
+		wait, err := gcraScript.Run(ctx, l.client, []string{l.limit.Key}, l.limit.Rate, l.limit.Burst).Int64()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if atomic.CompareAndSwapInt32(&l.fallingBack, 0, 1) {
+				logger.Errorf("shared rate limit %s unavailable, limiting locally: %s", l.limit.Key, err)
+			}
+			return l.fallback.Wait(ctx)
+		}
+		if atomic.CompareAndSwapInt32(&l.fallingBack, 1, 0) {
+			logger.Info("shared rate limit " + l.limit.Key + " available again")
+		}
+		if wait == 0 {
+			return nil
+		}
+
+		timer := time.NewTimer(time.Duration(wait) * time.Microsecond)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
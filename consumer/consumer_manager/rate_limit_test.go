@@ -0,0 +1,91 @@
+package consumer_manager
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+func waitAll(t *testing.T, limiter *queueRateLimiter, eventName string, n int) time.Duration {
+	start := time.Now()
+	for i := 0; i < n; i++ {
+		if err := limiter.wait(context.Background(), eventName); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return time.Since(start)
+}
+
+func TestRateLimit(t *testing.T) {
+	limiter := newQueueRateLimiter("queue", RateLimitConfig{
+		Limit:  RateLimit{Rate: 100, Burst: 2},
+		Events: map[string]RateLimit{"slow": {Rate: 20}},
+	})
+
+	// the burst is free, the 4 others take 10ms each
+	if elapsed := waitAll(t, limiter, "fast", 6); elapsed < 35*time.Millisecond || elapsed > 500*time.Millisecond {
+		t.Errorf("expect the queue rate, took %s", elapsed)
+	}
+	if elapsed := waitAll(t, limiter, "slow", 3); elapsed < 90*time.Millisecond {
+		t.Errorf("expect the event rate, took %s", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := limiter.wait(ctx, "slow"); err == nil {
+		t.Error("expect wait to give up once ctx is done")
+	}
+
+	if unlimited := newQueueRateLimiter("queue", RateLimitConfig{}); waitAll(t, unlimited, "fast", 100) > 50*time.Millisecond {
+		t.Error("expect no limit without rate")
+	}
+}
+
+func TestRateLimit_Redis(t *testing.T) {
+	server, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	defer client.Close()
+
+	// two replicas of the service share the limit
+	config := RateLimitConfig{Limit: RateLimit{Rate: 20, Redis: client}}
+	replicas := []*queueRateLimiter{newQueueRateLimiter("queue", config), newQueueRateLimiter("queue", config)}
+	start := time.Now()
+	for i := 0; i < 6; i++ {
+		if err := replicas[i%2].wait(context.Background(), ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 240*time.Millisecond {
+		t.Errorf("expect the limit shared by the replicas, took %s", elapsed)
+	}
+	if !server.Exists(RateLimitKeyPrefix + "queue") {
+		t.Error("expect the limit state in redis")
+	}
+
+	server.Close()
+	if elapsed := waitAll(t, replicas[0], "", 2); elapsed > time.Second {
+		t.Errorf("expect the local limit while redis is down, took %s", elapsed)
+	}
+	shared := replicas[0].queue.(*redisRateLimiter)
+	if atomic.LoadInt32(&shared.fallingBack) != 1 {
+		t.Error("expect the limiter falling back while redis is down")
+	}
+
+	if err := server.Restart(); err != nil {
+		t.Fatal(err)
+	}
+	if err := replicas[0].wait(context.Background(), ""); err != nil {
+		t.Fatal(err)
+	}
+	if atomic.LoadInt32(&shared.fallingBack) != 0 {
+		t.Error("expect the shared limit used again once redis is back")
+	}
+}
@@ -0,0 +1,263 @@
+package consumer_manager
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/best-expendables/eventbus-client/consumer/base_consumer"
+	"github.com/best-expendables/logger"
+)
+
+// ErrManagerShutDown the manager is shut down, its workers can not be changed anymore
+var ErrManagerShutDown = errors.New("consumer manager is shut down")
+
+// AutoscaleConfig bounds of the workers of a queue and when to add or remove one, zero values take the defaults
+type AutoscaleConfig struct {
+	Min int
+	Max int
+	// Interval between two scaling decisions, 10s by default
+	Interval time.Duration
+	// ScaleUpBusyRatio share of the workers time spent consuming above which a worker is added, 0.8 by default
+	ScaleUpBusyRatio float64
+	// ScaleDownBusyRatio share of the workers time spent consuming below which a worker is removed, 0.3 by default
+	ScaleDownBusyRatio float64
+	// MaxLatency average handler latency above which no worker is added, the downstream being the bottleneck. No limit when zero
+	MaxLatency time.Duration
+	// ScaleUpBacklog messages ready in the broker queue per worker above which a worker is added, whatever the busy ratio.
+	// No worker is removed while messages are ready. The backlog is not inspected when zero
+	ScaleUpBacklog int
+}
+
+func (c AutoscaleConfig) withDefaults() AutoscaleConfig {
+	if c.Interval <= 0 {
+		c.Interval = 10 * time.Second
+	}
+	if c.ScaleUpBusyRatio <= 0 {
+		c.ScaleUpBusyRatio = 0.8
+	}
+	if c.ScaleDownBusyRatio <= 0 {
+		c.ScaleDownBusyRatio = 0.3
+	}
+	return c
+}
+
+// decide the number of workers from the time they spent consuming during the last interval
+// and the messages ready in the queue, backlog is negative when unknown
+func (c AutoscaleConfig) decide(workers int, busy time.Duration, consumed int64, backlog int) int {
+	ratio := float64(busy) / float64(time.Duration(workers)*c.Interval)
+	behind := c.ScaleUpBacklog > 0 && backlog > c.ScaleUpBacklog*workers
+	switch {
+	case workers < c.Min:
+		return c.Min
+	case workers > c.Max:
+		return c.Max
+	case (ratio >= c.ScaleUpBusyRatio || behind) && workers < c.Max:
+		if c.MaxLatency > 0 && consumed > 0 && busy/time.Duration(consumed) > c.MaxLatency {
+			return workers
+		}
+		return workers + 1
+	case ratio < c.ScaleDownBusyRatio && backlog <= 0 && workers > c.Min:
+		return workers - 1
+	default:
+		return workers
+	}
+}
+
+// QueueStatus consumption state of a queue
+type QueueStatus struct {
+	Workers  int
+	InFlight int
+	// LastAck last time a delivery was acked, rejected or requeued on the broker, zero before the first one
+	LastAck time.Time
+	// WaitingSince messages are in flight without any ack since then, zero when none is in flight
+	WaitingSince time.Time
+}
+
+// queueWorkers workers consuming a queue and the load they observed
+type queueWorkers struct {
+	// busy nanoseconds spent consuming and consumed messages, reset by the autoscaler. First for 64-bit atomic alignment
+	busy     int64
+	consumed int64
+
+	stops         []chan interface{}
+	stopAutoscale chan interface{}
+
+	mu           sync.Mutex
+	inFlight     int
+	lastAck      time.Time
+	waitingSince time.Time
+}
+
+func (w *queueWorkers) observe(duration time.Duration) {
+	atomic.AddInt64(&w.busy, int64(duration))
+	atomic.AddInt64(&w.consumed, 1)
+}
+
+// begin a delivery taken by a worker
+func (w *queueWorkers) begin() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.inFlight == 0 {
+		w.waitingSince = time.Now()
+	}
+	w.inFlight++
+}
+
+// end a delivery, acked when the broker took its acknowledgement
+func (w *queueWorkers) end(acked bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.inFlight--
+	if acked {
+		w.lastAck = time.Now()
+		w.waitingSince = w.lastAck
+	}
+	if w.inFlight == 0 {
+		w.waitingSince = time.Time{}
+	}
+}
+
+// addWorker start a worker on the queue, the caller holds the lock
+func (c *consumerManager) addWorker(queueName string, consumer base_consumer.Consumer, workers *queueWorkers) {
+	stop := make(chan interface{})
+	workers.stops = append(workers.stops, stop)
+	c.wg.Add(1)
+	go c.work(queueName, consumer, stop, workers)
+}
+
+// scale add or stop workers of the queue, stopped ones finish their current message. The caller holds the lock
+func (c *consumerManager) scale(queueName string, count int) error {
+	if c.isShuttingDown() {
+		return ErrManagerShutDown
+	}
+	workers, ok := c.workersByQueue[queueName]
+	if !ok {
+		return fmt.Errorf("queue %s is not consumed", queueName)
+	}
+	if count < 0 {
+		count = 0
+	}
+
+	for len(workers.stops) < count {
+		c.addWorker(queueName, c.consumerByQueue[queueName], workers)
+	}
+	for len(workers.stops) > count {
+		last := len(workers.stops) - 1
+		close(workers.stops[last])
+		workers.stops = workers.stops[:last]
+	}
+	return nil
+}
+
+// ScaleQueue set the number of workers consuming the queue, it stops the queue autoscaling
+func (c *consumerManager) ScaleQueue(queueName string, count int) error {
+	c.locker.Lock()
+	defer c.locker.Unlock()
+
+	if workers, ok := c.workersByQueue[queueName]; ok && workers.stopAutoscale != nil {
+		close(workers.stopAutoscale)
+		workers.stopAutoscale = nil
+	}
+	if err := c.scale(queueName, count); err != nil {
+		return err
+	}
+	logger.Infof("queue %s scaled to %d worker(s)", queueName, count)
+	return nil
+}
+
+// AutoscaleQueue adjust the number of workers of the queue between config.Min and config.Max, every config.Interval.
+// A worker is added while they are busy most of the time or, with config.ScaleUpBacklog, while messages pile up in the queue,
+// unless handlers are slower than config.MaxLatency. One is removed while they are mostly idle and the queue is empty
+func (c *consumerManager) AutoscaleQueue(queueName string, config AutoscaleConfig) error {
+	config = config.withDefaults()
+	if config.Min < 1 || config.Max < config.Min {
+		return fmt.Errorf("invalid autoscaling bounds: min %d, max %d", config.Min, config.Max)
+	}
+
+	c.locker.Lock()
+	defer c.locker.Unlock()
+	workers, ok := c.workersByQueue[queueName]
+	if !ok {
+		return fmt.Errorf("queue %s is not consumed", queueName)
+	}
+	if workers.stopAutoscale != nil {
+		close(workers.stopAutoscale)
+	}
+	if err := c.scale(queueName, config.decide(len(workers.stops), 0, 0, -1)); err != nil {
+		return err
+	}
+	atomic.StoreInt64(&workers.busy, 0)
+	atomic.StoreInt64(&workers.consumed, 0)
+
+	stop := make(chan interface{})
+	workers.stopAutoscale = stop
+	go c.autoscale(queueName, config, workers, stop)
+	return nil
+}
+
+func (c *consumerManager) autoscale(queueName string, config AutoscaleConfig, workers *queueWorkers, stop <-chan interface{}) {
+	ticker := time.NewTicker(config.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.doneChan:
+			return
+		case <-stop:
+			return
+		case <-ticker.C:
+			busy := time.Duration(atomic.SwapInt64(&workers.busy, 0))
+			consumed := atomic.SwapInt64(&workers.consumed, 0)
+			backlog := -1
+			if config.ScaleUpBacklog > 0 {
+				if ready, err := c.deliveryChannelManager.Backlog(queueName); err == nil {
+					backlog = ready
+				}
+			}
+
+			c.locker.Lock()
+			select {
+			case <-stop:
+				// ScaleQueue took over while waiting for the lock
+			default:
+				current := len(workers.stops)
+				if count := config.decide(current, busy, consumed, backlog); count != current {
+					if err := c.scale(queueName, count); err == nil {
+						logger.Infof("queue %s autoscaled from %d to %d worker(s)", queueName, current, count)
+					}
+				}
+			}
+			c.locker.Unlock()
+		}
+	}
+}
+
+// Status consumption state of the started queues
+func (c *consumerManager) Status() map[string]QueueStatus {
+	c.locker.Lock()
+	defer c.locker.Unlock()
+	statuses := make(map[string]QueueStatus, len(c.workersByQueue))
+	for queueName, workers := range c.workersByQueue {
+		workers.mu.Lock()
+		statuses[queueName] = QueueStatus{
+			Workers:      len(workers.stops),
+			InFlight:     workers.inFlight,
+			LastAck:      workers.lastAck,
+			WaitingSince: workers.waitingSince,
+		}
+		workers.mu.Unlock()
+	}
+	return statuses
+}
+
+// Workers number of workers consuming the queue
+func (c *consumerManager) Workers(queueName string) int {
+	c.locker.Lock()
+	defer c.locker.Unlock()
+	if workers, ok := c.workersByQueue[queueName]; ok {
+		return len(workers.stops)
+	}
+	return 0
+}
@@ -0,0 +1,40 @@
+package consumer_manager
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAutoscaleConfig_Decide(t *testing.T) {
+	config := AutoscaleConfig{Min: 1, Max: 4, Interval: time.Second, MaxLatency: 100 * time.Millisecond, ScaleUpBacklog: 10}.withDefaults()
+
+	tests := []struct {
+		name     string
+		workers  int
+		busy     time.Duration
+		consumed int64
+		backlog  int
+		expect   int
+	}{
+		{"below min", 0, 0, 0, -1, 1},
+		{"above max", 6, 6 * time.Second, 600, -1, 4},
+		{"busy workers", 2, 1800 * time.Millisecond, 180, -1, 3},
+		{"busy at max", 4, 4 * time.Second, 400, -1, 4},
+		{"slow downstream", 2, 1800 * time.Millisecond, 6, -1, 2},
+		{"idle workers", 3, 300 * time.Millisecond, 30, -1, 2},
+		{"idle at min", 1, 0, 0, -1, 1},
+		{"steady load", 2, time.Second, 100, -1, 2},
+		{"busy with an empty queue", 2, 1800 * time.Millisecond, 180, 0, 3},
+		{"backlog growing", 2, time.Second, 100, 30, 3},
+		{"small backlog", 2, time.Second, 100, 20, 2},
+		{"backlog of slow downstream", 2, time.Second, 6, 30, 2},
+		{"idle with backlog", 3, 300 * time.Millisecond, 30, 5, 3},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := config.decide(test.workers, test.busy, test.consumed, test.backlog); got != test.expect {
+				t.Errorf("expect %d workers, got %d", test.expect, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package consumer_middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	eventbusclient "github.com/best-expendables/eventbus-client"
+	"github.com/best-expendables/eventbus-client/helper"
+	"github.com/best-expendables/eventbus-client/producer_manager"
+	pkgerrors "github.com/pkg/errors"
+)
+
+// Headers recording why a message was dead-lettered
+const (
+	DeadLetterErrorHeader              = "x-dlq-error"
+	DeadLetterErrorClassHeader         = "x-dlq-error-class"
+	DeadLetterRetryCountHeader         = "x-dlq-retry-count"
+	DeadLetterOriginalExchangeHeader   = "x-dlq-original-exchange"
+	DeadLetterOriginalRoutingKeyHeader = "x-dlq-original-routing-key"
+	DeadLetterQueueHeader              = "x-dlq-queue"
+	DeadLetterFailedAtHeader           = "x-dlq-failed-at"
+)
+
+// DeadLetterConfig where failed messages are republished
+type DeadLetterConfig struct {
+	// Exchange the default exchange when empty
+	Exchange string
+	// RoutingKey the `<queue>.dead` queue of the consumed queue when empty, see Queue.DeadLetterQueue
+	RoutingKey string
+}
+
+// DeadLetter republish failed messages with headers recording the failure, then ack them.
+// A message is dead-lettered when it is rejected, as RetryWithError does once retries are exhausted,
+// or when its error is not retryable. Use it before RetryWithError so it sees the retry outcome.
+// When republishing fails the message is rejected, the broker dead-letter exchange of the queue applies.
+// The original headers hold the exchange and routing key of the first consume, RetryWithError records them on retries
+func DeadLetter(publisher producer_manager.Producer, config DeadLetterConfig) Middleware {
+	return func(next ConsumeFunc) ConsumeFunc {
+		return func(ctx context.Context, message *eventbusclient.Message) {
+			defer func() {
+				if message.Error == nil {
+					return
+				}
+				_, retryable := message.Error.(eventbusclient.RetryErrorType)
+				if retryable && message.Status != eventbusclient.MessageStatusReject {
+					return
+				}
+
+				deadLetter := newDeadLetterMessage(message, config)
+				logEntry := helper.LoggerFromCtx(ctx)
+				fields := helper.GetLogFieldFromMessage(message)
+				if err := publisher.Publish(ctx, deadLetter); err != nil {
+					logEntry.WithFields(fields).Errorf("dead letter publish failed: %s", err)
+					message.Error = pkgerrors.Wrap(message.Error, fmt.Sprintf("failed to publish dead letter. Error: %s", err))
+					message.Status = eventbusclient.MessageStatusReject
+					return
+				}
+				logEntry.WithFields(fields).Errorf("MessageDeadLettered: %s", message.Error)
+				message.Status = eventbusclient.MessageStatusAck
+			}()
+			next(ctx, message)
+		}
+	}
+}
+
+func newDeadLetterMessage(message *eventbusclient.Message, config DeadLetterConfig) *eventbusclient.Message {
+	deadLetter := *message
+	deadLetter.Exchange = config.Exchange
+	deadLetter.RoutingKey = config.RoutingKey
+	if deadLetter.RoutingKey == "" {
+		deadLetter.RoutingKey = eventbusclient.DeadLetterName(message.Queue)
+	}
+	deadLetter.Status = ""
+	deadLetter.Error = nil
+
+	deadLetter.Header.Extra = map[string]interface{}{}
+	for key, value := range message.Header.Extra {
+		deadLetter.Header.Extra[key] = value
+	}
+	deadLetter.Header.SetExtra(DeadLetterErrorHeader, message.Error.Error())
+	deadLetter.Header.SetExtra(DeadLetterErrorClassHeader, errorClass(message.Error))
+	deadLetter.Header.SetExtra(DeadLetterRetryCountHeader, int64(message.Header.XRetryCount))
+	stampOrigin(&deadLetter.Header, message)
+	deadLetter.Header.SetExtra(DeadLetterQueueHeader, message.Queue)
+	deadLetter.Header.SetExtra(DeadLetterFailedAtHeader, time.Now().UTC().Format(time.RFC3339))
+
+	return &deadLetter
+}
+
+// stampOrigin record in header the exchange and routing key the message was consumed from,
+// unless a retry recorded them already: the message then came back from its delayed queue
+func stampOrigin(header *eventbusclient.Header, message *eventbusclient.Message) {
+	if _, stamped := message.Header.Extra[DeadLetterOriginalExchangeHeader]; stamped {
+		return
+	}
+	header.SetExtra(DeadLetterOriginalExchangeHeader, message.Exchange)
+	header.SetExtra(DeadLetterOriginalRoutingKeyHeader, message.RoutingKey)
+}
+
+// errorClass type of the root cause, through retry errors and github.com/pkg/errors wrapping
+func errorClass(err error) string {
+	for {
+		if cause := pkgerrors.Cause(err); cause != err {
+			err = cause
+			continue
+		}
+		unwrapped := errors.Unwrap(err)
+		if unwrapped == nil {
+			return fmt.Sprintf("%T", err)
+		}
+		err = unwrapped
+	}
+}
@@ -0,0 +1,224 @@
+package consumer_middleware
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	eventbusclient "github.com/best-expendables/eventbus-client"
+	"github.com/best-expendables/eventbus-client/helper"
+	"github.com/best-expendables/eventbus-client/producer_manager"
+	"github.com/best-expendables/eventbus-client/transport"
+	pkgerrors "github.com/pkg/errors"
+	"github.com/streadway/amqp"
+)
+
+const testQueue = "package_creation"
+
+type validationError struct{}
+
+func (validationError) Error() string { return "invalid package" }
+
+func newDeadLetterTest(t *testing.T) (*transport.MemoryBroker, producer_manager.Producer) {
+	broker := transport.NewMemoryBroker()
+	p, err := producer_manager.NewProducerWithConfig(&eventbusclient.Config{
+		Transport: broker,
+		Topology:  &eventbusclient.Topology{Queues: []eventbusclient.Queue{{Name: testQueue, DeadLetterQueue: true}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return broker, p
+}
+
+func newConsumedMessage() *eventbusclient.Message {
+	return &eventbusclient.Message{
+		Id:         "1",
+		Exchange:   "package",
+		RoutingKey: "package.created",
+		Queue:      testQueue,
+		Header: eventbusclient.Header{
+			Timestamp:   time.Now(),
+			Publisher:   "package",
+			EventName:   "package_creation",
+			XRetryCount: 2,
+		},
+		Payload: eventbusclient.Payload{EntityId: "entity", Data: map[string]interface{}{"id": "1"}},
+		Status:  eventbusclient.MessageStatusAck,
+	}
+}
+
+func TestDeadLetter_NonRetryableError(t *testing.T) {
+	broker, p := newDeadLetterTest(t)
+	defer p.Close()
+
+	message := newConsumedMessage()
+	DeadLetter(p, DeadLetterConfig{})(func(ctx context.Context, message *eventbusclient.Message) {
+		// a message forwarded by the handler must not carry the dead-letter headers
+		if _, stamped := message.Header.Extra[DeadLetterOriginalExchangeHeader]; stamped {
+			t.Error("expect the consumed message without dead-letter headers")
+		}
+		message.Error = pkgerrors.Wrap(validationError{}, "consume")
+	})(context.Background(), message)
+
+	if message.Status != eventbusclient.MessageStatusAck {
+		t.Errorf("expect dead-lettered message to be acked, got %s", message.Status)
+	}
+
+	conn, _ := broker.Dial("")
+	defer conn.Close()
+	ch, _ := conn.Channel()
+	deliveries, _ := ch.Consume(eventbusclient.DeadLetterName(testQueue), "", true, false, false, false, nil)
+	select {
+	case delivery := <-deliveries:
+		msg, err := helper.GetMessageFromDelivery(delivery)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := map[string]interface{}{
+			DeadLetterErrorHeader:              "consume: invalid package",
+			DeadLetterErrorClassHeader:         "consumer_middleware.validationError",
+			DeadLetterRetryCountHeader:         int64(2),
+			DeadLetterOriginalExchangeHeader:   "package",
+			DeadLetterOriginalRoutingKeyHeader: "package.created",
+			DeadLetterQueueHeader:              testQueue,
+		}
+		for key, value := range expected {
+			if msg.Header.Extra[key] != value {
+				t.Errorf("header %s: expect %v, got %v", key, value, msg.Header.Extra[key])
+			}
+		}
+		if _, err := time.Parse(time.RFC3339, msg.Header.Extra[DeadLetterFailedAtHeader].(string)); err != nil {
+			t.Errorf("wrong failure time: %s", err)
+		}
+		if msg.Id != "1" || msg.Header.EventName != "package_creation" {
+			t.Errorf("expect original message, got %+v", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expect message in dead letter queue")
+	}
+}
+
+func TestDeadLetter_Retryable(t *testing.T) {
+	broker, p := newDeadLetterTest(t)
+	defer p.Close()
+	h := DeadLetter(p, DeadLetterConfig{})
+
+	retried := newConsumedMessage()
+	h(func(ctx context.Context, message *eventbusclient.Message) {
+		message.Error = eventbusclient.NewRetryError(errors.New("timeout"))
+	})(context.Background(), retried)
+	if broker.MessageCount(eventbusclient.DeadLetterName(testQueue)) != 0 {
+		t.Error("expect retried message not to be dead-lettered")
+	}
+
+	exhausted := newConsumedMessage()
+	h(func(ctx context.Context, message *eventbusclient.Message) {
+		message.Error = eventbusclient.NewRetryError(errors.New("timeout"))
+		message.Status = eventbusclient.MessageStatusReject
+	})(context.Background(), exhausted)
+	if broker.MessageCount(eventbusclient.DeadLetterName(testQueue)) != 1 {
+		t.Error("expect exhausted message to be dead-lettered")
+	}
+	if exhausted.Status != eventbusclient.MessageStatusAck {
+		t.Errorf("expect dead-lettered message to be acked, got %s", exhausted.Status)
+	}
+}
+
+func TestDeadLetter_PublishFails(t *testing.T) {
+	_, p := newDeadLetterTest(t)
+	defer p.Close()
+
+	message := newConsumedMessage()
+	DeadLetter(p, DeadLetterConfig{RoutingKey: "no_queue_bound"})(func(ctx context.Context, message *eventbusclient.Message) {
+		message.Error = errors.New("failed")
+	})(context.Background(), message)
+
+	if message.Status != eventbusclient.MessageStatusReject {
+		t.Errorf("expect message to be rejected, got %s", message.Status)
+	}
+}
+
+func TestDeadLetter_ThroughDelayedRetries(t *testing.T) {
+	broker := transport.NewMemoryBroker()
+	p, err := producer_manager.NewProducerWithConfig(&eventbusclient.Config{
+		Transport: broker,
+		Topology: &eventbusclient.Topology{
+			Exchanges: []eventbusclient.Exchange{{Name: "package"}},
+			Queues: []eventbusclient.Queue{{
+				Name:            testQueue,
+				DeadLetterQueue: true,
+				// retried messages come back through the default exchange
+				DelayedRetry: &eventbusclient.DelayedRetry{TTL: 10 * time.Millisecond, RoutingKey: testQueue},
+			}},
+			Bindings: []eventbusclient.Binding{{Queue: testQueue, Exchange: "package", RoutingKey: "package.created"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	conn, _ := broker.Dial("")
+	defer conn.Close()
+	ch, _ := conn.Channel()
+	deliveries, _ := ch.Consume(testQueue, "", true, false, false, false, nil)
+	dead, _ := ch.Consume(eventbusclient.DeadLetterName(testQueue), "", true, false, false, false, nil)
+
+	published := newConsumedMessage()
+	published.Header.XRetryCount = 0
+	if err := p.Publish(context.Background(), published); err != nil {
+		t.Fatal(err)
+	}
+
+	h := DeadLetter(p, DeadLetterConfig{})(RetryWithError(p, 2)(func(ctx context.Context, message *eventbusclient.Message) {
+		message.Error = eventbusclient.NewRetryError(errors.New("timeout"))
+	}))
+	var consumed []*eventbusclient.Message
+	for i := 0; i < 3; i++ {
+		var delivery amqp.Delivery
+		select {
+		case delivery = <-deliveries:
+		case <-time.After(time.Second):
+			t.Fatalf("expect delivery %d", i+1)
+		}
+		if deaths, _ := delivery.Headers["x-death"].([]interface{}); i > 0 && (len(deaths) != 1 || deaths[0].(amqp.Table)["count"] != int64(1)) {
+			t.Errorf("expect x-death not to grow with retries, got: %v", delivery.Headers["x-death"])
+		}
+		msg, err := helper.GetMessageFromDelivery(delivery)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg.Queue = testQueue
+		consumed = append(consumed, msg)
+		h(context.Background(), msg)
+	}
+
+	// both retry cycles deliver the same application headers, without the broker ones
+	if !reflect.DeepEqual(consumed[1].Header.Extra, consumed[2].Header.Extra) {
+		t.Errorf("expect headers to stay the same across retries, got %v then %v", consumed[1].Header.Extra, consumed[2].Header.Extra)
+	}
+	for key := range consumed[2].Header.Extra {
+		if eventbusclient.IsBrokerHeader(key) {
+			t.Errorf("expect broker header %s not to be kept", key)
+		}
+	}
+
+	select {
+	case delivery := <-dead:
+		msg, err := helper.GetMessageFromDelivery(delivery)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg.Header.Extra[DeadLetterOriginalExchangeHeader] != "package" || msg.Header.Extra[DeadLetterOriginalRoutingKeyHeader] != "package.created" {
+			t.Errorf("expect the exchange and routing key of the first publishing, got: %v", msg.Header.Extra)
+		}
+		if msg.Header.Extra[DeadLetterRetryCountHeader] != int64(3) {
+			t.Errorf("wrong retry count: %v", msg.Header.Extra[DeadLetterRetryCountHeader])
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expect message in dead letter queue")
+	}
+}
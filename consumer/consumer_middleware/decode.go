@@ -0,0 +1,23 @@
+package consumer_middleware
+
+import (
+	"context"
+
+	eventbusclient "github.com/best-expendables/eventbus-client"
+)
+
+// DecodePayload decode the payload data into the type registered for the event, see Message.TypedData.
+// A message failing to decode or validate is rejected with a *eventbusclient.DecodeError without reaching the handler.
+// Use it last so the other middlewares see the failure
+func DecodePayload(registry *eventbusclient.EventRegistry) Middleware {
+	return func(next ConsumeFunc) ConsumeFunc {
+		return func(ctx context.Context, message *eventbusclient.Message) {
+			if err := registry.Decode(message); err != nil {
+				message.Error = err
+				message.Status = eventbusclient.MessageStatusReject
+				return
+			}
+			next(ctx, message)
+		}
+	}
+}
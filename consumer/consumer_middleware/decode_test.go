@@ -0,0 +1,45 @@
+package consumer_middleware
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	eventbusclient "github.com/best-expendables/eventbus-client"
+)
+
+type packageCreated struct {
+	PackageId string `json:"packageId" validate:"required"`
+}
+
+func TestDecodePayload(t *testing.T) {
+	registry := eventbusclient.NewEventRegistry()
+	registry.Register("package_creation", packageCreated{})
+
+	var handled *eventbusclient.Message
+	h := DecodePayload(registry)(func(ctx context.Context, message *eventbusclient.Message) {
+		handled = message
+	})
+
+	valid := &eventbusclient.Message{
+		Header:  eventbusclient.Header{EventName: "package_creation"},
+		Payload: eventbusclient.Payload{Data: map[string]interface{}{"packageId": "P1"}},
+		Status:  eventbusclient.MessageStatusAck,
+	}
+	h(context.Background(), valid)
+	if data, ok := handled.TypedData().(*packageCreated); !ok || data.PackageId != "P1" {
+		t.Errorf("expect handler to receive typed data, got %+v", handled.TypedData())
+	}
+
+	handled = nil
+	invalid := &eventbusclient.Message{
+		Header:  eventbusclient.Header{EventName: "package_creation"},
+		Payload: eventbusclient.Payload{Data: map[string]interface{}{}},
+		Status:  eventbusclient.MessageStatusAck,
+	}
+	h(context.Background(), invalid)
+	var decodeErr *eventbusclient.DecodeError
+	if handled != nil || invalid.Status != eventbusclient.MessageStatusReject || !errors.As(invalid.Error, &decodeErr) {
+		t.Errorf("expect invalid message to be rejected before the handler, got %+v", invalid)
+	}
+}
@@ -0,0 +1,149 @@
+package consumer_middleware
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	eventbusclient "github.com/best-expendables/eventbus-client"
+	"github.com/best-expendables/eventbus-client/helper"
+	"github.com/go-redis/redis/v8"
+)
+
+const defaultDedupCapacity = 10000
+
+// DedupStore remember the ids of processed messages.
+// Consumers of different queues sharing a store must use different key prefixes
+type DedupStore interface {
+	// Seen report whether the message id was marked processed and did not expire yet
+	Seen(ctx context.Context, id string) (bool, error)
+	// Mark remember the message id as processed for ttl
+	Mark(ctx context.Context, id string, ttl time.Duration) error
+}
+
+// Deduplicate ack messages already processed without consuming them again.
+// A message is marked processed only when it is acked without error, so failures are still retried.
+// Messages without id are always consumed, store errors are logged and the message is consumed
+func Deduplicate(store DedupStore, ttl time.Duration) Middleware {
+	return func(next ConsumeFunc) ConsumeFunc {
+		return func(ctx context.Context, message *eventbusclient.Message) {
+			if message.Id == "" {
+				next(ctx, message)
+				return
+			}
+
+			logEntry := helper.LoggerFromCtx(ctx)
+			seen, err := store.Seen(ctx, message.Id)
+			if err != nil {
+				logEntry.WithFields(helper.GetLogFieldFromMessage(message)).Errorf("dedup store lookup failed: %s", err)
+			}
+			if seen {
+				logEntry.WithFields(helper.GetLogFieldFromMessage(message)).Info("MessageDuplicated")
+				message.Status = eventbusclient.MessageStatusAck
+				message.Error = nil
+				return
+			}
+
+			next(ctx, message)
+
+			if message.Error != nil || message.Status != eventbusclient.MessageStatusAck {
+				return
+			}
+			if err := store.Mark(ctx, message.Id, ttl); err != nil {
+				logEntry.WithFields(helper.GetLogFieldFromMessage(message)).Errorf("dedup store mark failed: %s", err)
+			}
+		}
+	}
+}
+
+type redisDedupStore struct {
+	client *redis.Client
+	prefix string
+}
+
+// NewRedisDedupStore store processed ids as redis keys expiring with the ttl
+func NewRedisDedupStore(client *redis.Client, prefix string) DedupStore {
+	return &redisDedupStore{client: client, prefix: prefix}
+}
+
+func (s *redisDedupStore) Seen(ctx context.Context, id string) (bool, error) {
+	count, err := s.client.Exists(ctx, s.prefix+id).Result()
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+// Mark set the key only when missing, a redelivery processed concurrently by another replica keeps the first expiry
+func (s *redisDedupStore) Mark(ctx context.Context, id string, ttl time.Duration) error {
+	return s.client.SetNX(ctx, s.prefix+id, 1, ttl).Err()
+}
+
+type memoryDedupEntry struct {
+	id        string
+	expiresAt time.Time
+}
+
+type memoryDedupStore struct {
+	mu       sync.Mutex
+	capacity int
+	entries  map[string]*list.Element
+	order    *list.List
+}
+
+// NewMemoryDedupStore keep processed ids in process memory, the least recently seen ids are evicted past capacity.
+// It only deduplicates redeliveries to the same process
+func NewMemoryDedupStore(capacity int) DedupStore {
+	if capacity <= 0 {
+		capacity = defaultDedupCapacity
+	}
+
+	return &memoryDedupStore{
+		capacity: capacity,
+		entries:  map[string]*list.Element{},
+		order:    list.New(),
+	}
+}
+
+func (s *memoryDedupStore) Seen(ctx context.Context, id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	element, ok := s.entries[id]
+	if !ok {
+		return false, nil
+	}
+	if time.Now().After(element.Value.(*memoryDedupEntry).expiresAt) {
+		s.remove(element)
+		return false, nil
+	}
+	s.order.MoveToFront(element)
+
+	return true, nil
+}
+
+func (s *memoryDedupStore) Mark(ctx context.Context, id string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	expiresAt := time.Now().Add(ttl)
+	if element, ok := s.entries[id]; ok {
+		element.Value.(*memoryDedupEntry).expiresAt = expiresAt
+		s.order.MoveToFront(element)
+		return nil
+	}
+
+	s.entries[id] = s.order.PushFront(&memoryDedupEntry{id: id, expiresAt: expiresAt})
+	for s.order.Len() > s.capacity {
+		s.remove(s.order.Back())
+	}
+
+	return nil
+}
+
+func (s *memoryDedupStore) remove(element *list.Element) {
+	s.order.Remove(element)
+	delete(s.entries, element.Value.(*memoryDedupEntry).id)
+}
@@ -0,0 +1,118 @@
+package consumer_middleware
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	eventbusclient "github.com/best-expendables/eventbus-client"
+	"github.com/go-redis/redis/v8"
+)
+
+func TestDeduplicate(t *testing.T) {
+	store := NewMemoryDedupStore(10)
+	var consumed int
+	fail := true
+	h := Deduplicate(store, time.Minute)(func(ctx context.Context, message *eventbusclient.Message) {
+		consumed++
+		if fail {
+			message.Error = errors.New("failed")
+		}
+	})
+
+	newMessage := func() *eventbusclient.Message {
+		return &eventbusclient.Message{Id: "1", Status: eventbusclient.MessageStatusAck}
+	}
+
+	h(context.Background(), newMessage())
+	fail = false
+	h(context.Background(), newMessage())
+	if consumed != 2 {
+		t.Fatalf("expect failed message to be consumed again, consumed %d times", consumed)
+	}
+
+	duplicate := newMessage()
+	h(context.Background(), duplicate)
+	if consumed != 2 {
+		t.Error("expect duplicate not to be consumed")
+	}
+	if duplicate.Status != eventbusclient.MessageStatusAck || duplicate.Error != nil {
+		t.Errorf("expect duplicate to be acked, got %+v", duplicate)
+	}
+
+	h(context.Background(), &eventbusclient.Message{Status: eventbusclient.MessageStatusAck})
+	h(context.Background(), &eventbusclient.Message{Status: eventbusclient.MessageStatusAck})
+	if consumed != 4 {
+		t.Error("expect messages without id to be always consumed")
+	}
+}
+
+func TestMemoryDedupStore(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryDedupStore(2)
+
+	_ = store.Mark(ctx, "1", time.Minute)
+	_ = store.Mark(ctx, "2", time.Minute)
+	// "1" is used last, "2" is evicted
+	_, _ = store.Seen(ctx, "1")
+	_ = store.Mark(ctx, "3", time.Minute)
+
+	for id, expected := range map[string]bool{"1": true, "2": false, "3": true} {
+		if seen, _ := store.Seen(ctx, id); seen != expected {
+			t.Errorf("id %s: expect seen %v", id, expected)
+		}
+	}
+
+	_ = store.Mark(ctx, "expired", -time.Second)
+	if seen, _ := store.Seen(ctx, "expired"); seen {
+		t.Error("expect expired id not to be seen")
+	}
+}
+
+func TestRedisDedupStore(t *testing.T) {
+	server, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	defer client.Close()
+
+	ctx := context.Background()
+	store := NewRedisDedupStore(client, "package_creation:")
+	var consumed int
+	h := Deduplicate(store, time.Minute)(func(ctx context.Context, message *eventbusclient.Message) {
+		consumed++
+	})
+
+	h(ctx, &eventbusclient.Message{Id: "1", Status: eventbusclient.MessageStatusAck})
+	if !server.Exists("package_creation:1") || server.TTL("package_creation:1") != time.Minute {
+		t.Fatalf("expect the id marked with the ttl, keys: %v", server.Keys())
+	}
+	h(ctx, &eventbusclient.Message{Id: "1", Status: eventbusclient.MessageStatusAck})
+	if consumed != 1 {
+		t.Errorf("expect duplicate not to be consumed, consumed %d times", consumed)
+	}
+	server.FastForward(30 * time.Second)
+	if err := store.Mark(ctx, "1", time.Hour); err != nil || server.TTL("package_creation:1") != 30*time.Second {
+		t.Errorf("expect marking again to keep the first expiry, got ttl %s, %v", server.TTL("package_creation:1"), err)
+	}
+
+	server.FastForward(30 * time.Second)
+	if seen, err := store.Seen(ctx, "1"); seen || err != nil {
+		t.Errorf("expect expired id not to be seen, got %v, %v", seen, err)
+	}
+	h(ctx, &eventbusclient.Message{Id: "1", Status: eventbusclient.MessageStatusAck})
+	if consumed != 2 {
+		t.Errorf("expect message consumed again once its id expired, consumed %d times", consumed)
+	}
+
+	// a store error does not stop consumption
+	server.Close()
+	h(ctx, &eventbusclient.Message{Id: "2", Status: eventbusclient.MessageStatusAck})
+	if consumed != 3 {
+		t.Errorf("expect message consumed while redis is down, consumed %d times", consumed)
+	}
+}
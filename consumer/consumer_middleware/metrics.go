@@ -0,0 +1,51 @@
+package consumer_middleware
+
+import (
+	"context"
+	"time"
+
+	eventbusclient "github.com/best-expendables/eventbus-client"
+)
+
+type metricsContextKey struct{}
+
+// consumeObservation lets Recover report a panic it swallowed to Metrics
+type consumeObservation struct {
+	panicked bool
+}
+
+// Metrics report consumed messages to the sink: outcome per queue and event, and handler latency.
+// Use it first so it sees the final status set by the other middlewares, panics recovered by Recover are still counted
+func Metrics(sink eventbusclient.MetricsSink) Middleware {
+	return func(next ConsumeFunc) ConsumeFunc {
+		return func(ctx context.Context, message *eventbusclient.Message) {
+			queue, event := message.Queue, message.Header.EventName
+			observation := &consumeObservation{}
+			ctx = context.WithValue(ctx, metricsContextKey{}, observation)
+			sink.MessageOutcome(queue, event, eventbusclient.OutcomeConsumed)
+
+			start := time.Now()
+			defer func() {
+				sink.HandlerDuration(queue, event, time.Since(start))
+				if r := recover(); r != nil {
+					sink.MessageOutcome(queue, event, eventbusclient.OutcomePanic)
+					panic(r)
+				}
+				if observation.panicked {
+					sink.MessageOutcome(queue, event, eventbusclient.OutcomePanic)
+				}
+				if _, retryable := message.Error.(eventbusclient.RetryErrorType); retryable && message.Status == eventbusclient.MessageStatusAck {
+					sink.MessageOutcome(queue, event, eventbusclient.OutcomeRetry)
+				}
+				sink.MessageOutcome(queue, event, message.Status)
+			}()
+			next(ctx, message)
+		}
+	}
+}
+
+func markPanicked(ctx context.Context) {
+	if observation, ok := ctx.Value(metricsContextKey{}).(*consumeObservation); ok {
+		observation.panicked = true
+	}
+}
@@ -15,6 +15,7 @@ func Recover(next ConsumeFunc) ConsumeFunc {
 	return func(ctx context.Context, message *eventbusclient.Message) {
 		defer func() {
 			if r := recover(); r != nil {
+				markPanicked(ctx)
 				logEntry := helper.LoggerFromCtx(ctx)
 
 				fields := helper.GetLogFieldFromMessage(message)
@@ -30,6 +31,7 @@ func RecoverWithRetry(next ConsumeFunc) ConsumeFunc {
 	return func(ctx context.Context, message *eventbusclient.Message) {
 		defer func() {
 			if r := recover(); r != nil {
+				markPanicked(ctx)
 				message.Error = eventbusclient.NewRetryError(errors.Wrap(message.Error, "retry with panic error"))
 			}
 		}()
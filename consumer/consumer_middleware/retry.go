@@ -36,11 +36,13 @@ func RetryWithError(publisher producer_manager.Producer, retryCount int, delayRo
 				}
 				logEntry.WithFields(fields).Error(fmt.Sprintf("retry with error message: %v", message.Error))
 
+				// the retry goes through the delayed queue, DeadLetter reads where it was first consumed from
+				stampOrigin(&message.Header, message)
 				if len(delayRoutingKeys) > 0 {
 					message.RoutingKey = delayRoutingKeys[0]
 				} else {
-					if !strings.HasSuffix(message.RoutingKey, ".delayed") {
-						message.RoutingKey = fmt.Sprintf("%s.delayed", message.RoutingKey)
+					if !strings.HasSuffix(message.RoutingKey, eventbusclient.DelayedSuffix) {
+						message.RoutingKey = eventbusclient.DelayedName(message.RoutingKey)
 					}
 				}
 				if err := publisher.Publish(ctx, message); err != nil {
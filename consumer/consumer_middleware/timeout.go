@@ -0,0 +1,128 @@
+package consumer_middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync/atomic"
+	"time"
+
+	eventbusclient "github.com/best-expendables/eventbus-client"
+	"github.com/best-expendables/eventbus-client/helper"
+)
+
+// ErrHandlerTimeout the handler did not return before its deadline, the message error wraps it in a retry error
+var ErrHandlerTimeout = errors.New("handler timed out")
+
+// TimeoutConfig time given to the handler, the event one wins over the queue one, which wins over the default
+type TimeoutConfig struct {
+	Default time.Duration
+	Queues  map[string]time.Duration
+	Events  map[string]time.Duration
+	// UsePublisherDeadline shorten the timeout to the DeadlineHeader deadline set by the publisher
+	UsePublisherDeadline bool
+}
+
+func (c TimeoutConfig) timeout(message *eventbusclient.Message) time.Duration {
+	if timeout, ok := c.Events[message.Header.EventName]; ok {
+		return timeout
+	}
+	if timeout, ok := c.Queues[message.Queue]; ok {
+		return timeout
+	}
+	return c.Default
+}
+
+// handlerResult outcome of a handler run in its own goroutine
+type handlerResult struct {
+	message *eventbusclient.Message
+	panic   interface{}
+	stack   []byte
+}
+
+// abandonedHandlers handlers still running after their deadline
+var abandonedHandlers int64
+
+// AbandonedHandlers number of handlers still running after Timeout gave up on them
+func AbandonedHandlers() int64 {
+	return atomic.LoadInt64(&abandonedHandlers)
+}
+
+const (
+	handlerRunning int32 = iota
+	handlerReturned
+	handlerAbandoned
+)
+
+// Timeout give the handler a context with a deadline. The handler runs on a deep copy of the message in its own goroutine,
+// when it does not return in time the worker moves on and the message fails with a retryable ErrHandlerTimeout,
+// the handler result is then discarded. Messages whose publisher deadline passed are rejected without being consumed.
+// A goroutine can not be stopped: an abandoned handler keeps running, and holding what it uses, until it returns,
+// so handlers must return once the context is done. AbandonedHandlers counts the ones still running.
+// Use it after RetryWithError and DeadLetter so they handle the timeout
+func Timeout(config TimeoutConfig) Middleware {
+	return func(next ConsumeFunc) ConsumeFunc {
+		return func(ctx context.Context, message *eventbusclient.Message) {
+			var deadline time.Time
+			if timeout := config.timeout(message); timeout > 0 {
+				deadline = time.Now().Add(timeout)
+			}
+			if publisherDeadline, ok := message.Header.Deadline(); ok && config.UsePublisherDeadline {
+				if !publisherDeadline.After(time.Now()) {
+					message.Error = fmt.Errorf("publisher deadline %s passed: %w", publisherDeadline, ErrHandlerTimeout)
+					message.Status = eventbusclient.MessageStatusReject
+					return
+				}
+				if deadline.IsZero() || publisherDeadline.Before(deadline) {
+					deadline = publisherDeadline
+				}
+			}
+			if deadline.IsZero() {
+				next(ctx, message)
+				return
+			}
+
+			ctx, cancel := context.WithDeadline(ctx, deadline)
+			defer cancel()
+
+			clone := message.Clone()
+			state := handlerRunning
+			done := make(chan handlerResult, 1)
+			go func() {
+				defer func() {
+					r := recover()
+					if !atomic.CompareAndSwapInt32(&state, handlerRunning, handlerReturned) {
+						atomic.AddInt64(&abandonedHandlers, -1)
+					}
+					if r != nil {
+						done <- handlerResult{panic: r, stack: debug.Stack()}
+						return
+					}
+					done <- handlerResult{message: clone}
+				}()
+				next(ctx, clone)
+			}()
+
+			var result handlerResult
+			select {
+			case result = <-done:
+			case <-ctx.Done():
+				if atomic.CompareAndSwapInt32(&state, handlerRunning, handlerAbandoned) {
+					atomic.AddInt64(&abandonedHandlers, 1)
+					fields := helper.GetLogFieldFromMessage(message)
+					helper.LoggerFromCtx(ctx).WithFields(fields).Errorf("MessageTimeout: handler did not return before %s", deadline.Format(time.RFC3339Nano))
+					message.Error = eventbusclient.NewRetryError(ErrHandlerTimeout)
+					return
+				}
+				// the handler returned just in time
+				result = <-done
+			}
+			if result.panic != nil {
+				// raised again on the worker goroutine, where Recover is
+				panic(fmt.Sprintf("%v\n%s", result.panic, result.stack))
+			}
+			*message = *result.message
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package consumer_middleware
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	eventbusclient "github.com/best-expendables/eventbus-client"
+)
+
+func TestTimeout(t *testing.T) {
+	h := Timeout(TimeoutConfig{
+		Default: time.Hour,
+		Queues:  map[string]time.Duration{testQueue: 20 * time.Millisecond},
+		Events:  map[string]time.Duration{"package_update": time.Hour},
+	})
+	release := make(chan struct{})
+	returned := make(chan struct{})
+	defer func() {
+		close(release)
+		<-returned
+	}()
+	stuck := h(func(ctx context.Context, message *eventbusclient.Message) {
+		<-release
+		close(returned)
+	})
+
+	message := newConsumedMessage()
+	start := time.Now()
+	stuck(context.Background(), message)
+	if time.Since(start) > time.Second {
+		t.Fatal("expect the worker to move on after the queue timeout")
+	}
+	if _, retryable := message.Error.(eventbusclient.RetryErrorType); !retryable || !errors.Is(message.Error, ErrHandlerTimeout) {
+		t.Errorf("expect retryable timeout error, got %v", message.Error)
+	}
+
+	updated := newConsumedMessage()
+	updated.Header.EventName = "package_update"
+	h(func(ctx context.Context, message *eventbusclient.Message) {
+		if deadline, ok := ctx.Deadline(); !ok || time.Until(deadline) < time.Minute {
+			t.Errorf("expect the event timeout, got %v", deadline)
+		}
+		message.Status = eventbusclient.MessageStatusNack
+	})(context.Background(), updated)
+	if updated.Error != nil || updated.Status != eventbusclient.MessageStatusNack {
+		t.Errorf("expect handler changes kept, got %+v", updated)
+	}
+}
+
+func TestTimeout_PublisherDeadline(t *testing.T) {
+	h := Timeout(TimeoutConfig{Default: time.Hour, UsePublisherDeadline: true})
+
+	message := newConsumedMessage()
+	message.Header.SetDeadline(time.Now().Add(time.Minute))
+	h(func(ctx context.Context, message *eventbusclient.Message) {
+		if deadline, _ := ctx.Deadline(); time.Until(deadline) > time.Minute {
+			t.Errorf("expect the publisher deadline, got %v", deadline)
+		}
+	})(context.Background(), message)
+
+	expired := newConsumedMessage()
+	expired.Header.SetDeadline(time.Now().Add(-time.Second))
+	h(func(ctx context.Context, message *eventbusclient.Message) {
+		t.Error("expect expired message not to be consumed")
+	})(context.Background(), expired)
+	if !errors.Is(expired.Error, ErrHandlerTimeout) || expired.Status != eventbusclient.MessageStatusReject {
+		t.Errorf("expect expired message rejected, got %v", expired.Error)
+	}
+}
+
+func TestTimeout_Panic(t *testing.T) {
+	message := newConsumedMessage()
+	Recover(Timeout(TimeoutConfig{Default: time.Hour})(func(ctx context.Context, message *eventbusclient.Message) {
+		panic("handler failed")
+	}))(context.Background(), message)
+}
+
+func TestTimeout_AbandonedHandler(t *testing.T) {
+	// handlers released by the other tests may still be returning
+	waitAbandoned := func(count int64) {
+		deadline := time.Now().Add(time.Second)
+		for AbandonedHandlers() != count && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	waitAbandoned(0)
+	release := make(chan struct{})
+	returned := make(chan struct{})
+	message := newConsumedMessage()
+	message.Header.SetExtra("x-tags", []interface{}{"a"})
+	Timeout(TimeoutConfig{Default: 20 * time.Millisecond})(func(ctx context.Context, message *eventbusclient.Message) {
+		<-ctx.Done()
+		<-release
+		// written after the worker moved on, the original message must not see it
+		message.Payload.Data.(map[string]interface{})["id"] = "2"
+		message.Header.Extra["x-tags"].([]interface{})[0] = "b"
+		close(returned)
+	})(context.Background(), message)
+
+	if AbandonedHandlers() != 1 {
+		t.Errorf("expect the handler counted as abandoned, got %d", AbandonedHandlers())
+	}
+	close(release)
+	<-returned
+	if id := message.Payload.Data.(map[string]interface{})["id"]; id != "1" {
+		t.Errorf("expect the payload untouched by the abandoned handler, got %v", id)
+	}
+	if tag := message.Header.Extra["x-tags"].([]interface{})[0]; tag != "a" {
+		t.Errorf("expect the headers untouched by the abandoned handler, got %v", tag)
+	}
+	waitAbandoned(0)
+	if AbandonedHandlers() != 0 {
+		t.Errorf("expect the returned handler no longer counted, got %d", AbandonedHandlers())
+	}
+}
@@ -1,7 +1,10 @@
 package delivery_channel_manager
 
 import (
+	"errors"
 	"fmt"
+	"sort"
+	"strings"
 	"sync"
 
 	"github.com/best-expendables/eventbus-client/consumer/connection_initializer"
@@ -11,20 +14,32 @@ import (
 type DeliveryChannelManager interface {
 	GetDeliveryChan(queue string) <-chan amqp.Delivery
 	InitDeliveryChannelForQueue(queue string) error
+	CancelConsumers() error
 	Close()
 	ReconnectDeliveryChannel() error
 	NotifiedConnectionError()
 	ConnectionErrorSolved()
 	GetConnectionErrorChan() <-chan bool
+	Pause(queue string) error
+	Resume(queue string) error
+	IsPaused(queue string) bool
+	IsSubscribed(queue string) bool
+	Backlog(queue string) (int, error)
 }
 
+// ErrUnknownQueue the queue has no delivery channel, it is not consumed
+var ErrUnknownQueue = errors.New("queue is not consumed")
+
 func NewDeliveryChannelManager(initializer connection_initializer.ConnectionInitializer) DeliveryChannelManager {
 	return &deliveryChannelManager{
 		locker:                sync.Mutex{},
 		queueToDeliveryChan:   make(map[string]chan amqp.Delivery),
+		queueToConsumerTag:    make(map[string]string),
+		pausedQueues:          make(map[string]bool),
 		doneChan:              make(chan interface{}),
 		connectionInitializer: initializer,
 		havingConnectionError: false,
+		connectionErrorChan:   make(chan bool),
 	}
 }
 
@@ -32,59 +47,194 @@ type deliveryChannelManager struct {
 	connectionInitializer connection_initializer.ConnectionInitializer
 	locker                sync.Mutex
 	queueToDeliveryChan   map[string]chan amqp.Delivery
+	queueToConsumerTag    map[string]string
+	consumerTagSequence   int
+	pausedQueues          map[string]bool
+	// queueLocker guards the queue maps, connectionErrorChan sends hold locker
+	queueLocker           sync.Mutex
 	doneChan              chan interface{}
 	havingConnectionError bool
 	connectionErrorChan   chan bool
 }
 
 func (d *deliveryChannelManager) GetDeliveryChan(queue string) <-chan amqp.Delivery {
+	d.queueLocker.Lock()
+	defer d.queueLocker.Unlock()
 	return d.queueToDeliveryChan[queue]
 }
 
 func (d *deliveryChannelManager) InitDeliveryChannelForQueue(queue string) error {
+	d.queueLocker.Lock()
+	defer d.queueLocker.Unlock()
+	return d.initDeliveryChannel(queue)
+}
+
+// initDeliveryChannel subscribe to the queue unless it is paused, the caller holds queueLocker
+func (d *deliveryChannelManager) initDeliveryChannel(queue string) error {
+	_, existed := d.queueToDeliveryChan[queue]
+	if !existed {
+		d.queueToDeliveryChan[queue] = make(chan amqp.Delivery)
+	}
+	if d.pausedQueues[queue] {
+		return nil
+	}
 	ampqChannel, err := d.connectionInitializer.GetAMQPChannel()
 	if err != nil {
 		return err
 	}
-	_, existed := d.queueToDeliveryChan[queue]
-	if !existed {
-		d.queueToDeliveryChan[queue] = make(chan amqp.Delivery)
+	// the previous consumer is still subscribed when the channel survived, it would hold its prefetched messages
+	if previousTag, ok := d.queueToConsumerTag[queue]; ok {
+		_ = ampqChannel.Cancel(previousTag, false)
 	}
-	amqDeliveryChan, err := ampqChannel.Consume(queue, "", false, false, false, false, nil)
+	d.consumerTagSequence++
+	consumerTag := fmt.Sprintf("%s-%d", queue, d.consumerTagSequence)
+	amqDeliveryChan, err := ampqChannel.Consume(queue, consumerTag, false, false, false, false, nil)
 	if err != nil {
 		return fmt.Errorf("queue consume error: %s", err)
 	}
+	d.queueToConsumerTag[queue] = consumerTag
+	doneChan := d.doneChan
+	deliveryChan := d.queueToDeliveryChan[queue]
 	go func() {
 		for {
 			select {
-			case <-d.doneChan:
+			case <-doneChan:
 				return
 			case delivery, open := <-amqDeliveryChan:
 				if !open {
-					continue
+					// consumer cancelled or channel closed, ReconnectDeliveryChannel subscribes again
+					return
+				}
+				select {
+				case <-doneChan:
+					return
+				case deliveryChan <- delivery:
 				}
-				d.queueToDeliveryChan[queue] <- delivery
 			}
 		}
 	}()
 	return nil
 }
 
+// ReconnectDeliveryChannel subscribe again to every queue, paused ones stay paused
 func (d *deliveryChannelManager) ReconnectDeliveryChannel() error {
-	d.Close()
+	d.queueLocker.Lock()
+	defer d.queueLocker.Unlock()
+	close(d.doneChan)
 	d.doneChan = make(chan interface{})
 	for queue, _ := range d.queueToDeliveryChan {
-		if err := d.InitDeliveryChannelForQueue(queue); err != nil {
+		if err := d.initDeliveryChannel(queue); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
+// CancelConsumers stop the broker sending deliveries, the ones already received can still be acked.
+// Every consumer is cancelled even when some fail, their errors are returned together
+func (d *deliveryChannelManager) CancelConsumers() error {
+	d.queueLocker.Lock()
+	defer d.queueLocker.Unlock()
+	ampqChannel, err := d.connectionInitializer.GetAMQPChannel()
+	if err != nil {
+		return err
+	}
+	var failures []string
+	for queue, consumerTag := range d.queueToConsumerTag {
+		if err := ampqChannel.Cancel(consumerTag, false); err != nil {
+			failures = append(failures, fmt.Sprintf("cancel consumer %s: %s", consumerTag, err))
+			continue
+		}
+		delete(d.queueToConsumerTag, queue)
+	}
+	if len(failures) > 0 {
+		sort.Strings(failures)
+		return errors.New(strings.Join(failures, "; "))
+	}
+	return nil
+}
+
 func (d *deliveryChannelManager) Close() {
+	d.queueLocker.Lock()
+	defer d.queueLocker.Unlock()
 	close(d.doneChan)
 }
 
+// Pause cancel the broker consumer of the queue, the deliveries already received are still consumed.
+// The queue stays paused across reconnects until Resume
+func (d *deliveryChannelManager) Pause(queue string) error {
+	d.queueLocker.Lock()
+	defer d.queueLocker.Unlock()
+	if _, ok := d.queueToDeliveryChan[queue]; !ok {
+		return ErrUnknownQueue
+	}
+	d.pausedQueues[queue] = true
+
+	consumerTag, subscribed := d.queueToConsumerTag[queue]
+	if !subscribed {
+		return nil
+	}
+	ampqChannel, err := d.connectionInitializer.GetAMQPChannel()
+	if err != nil {
+		// connection lost, the queue is not subscribed again on reconnect
+		delete(d.queueToConsumerTag, queue)
+		return nil
+	}
+	if err := ampqChannel.Cancel(consumerTag, false); err != nil && err != amqp.ErrClosed {
+		return fmt.Errorf("cancel consumer %s: %s", consumerTag, err)
+	}
+	delete(d.queueToConsumerTag, queue)
+	return nil
+}
+
+// Resume subscribe again to a paused queue. On error the queue is subscribed on the next reconnect
+func (d *deliveryChannelManager) Resume(queue string) error {
+	d.queueLocker.Lock()
+	defer d.queueLocker.Unlock()
+	if _, ok := d.queueToDeliveryChan[queue]; !ok {
+		return ErrUnknownQueue
+	}
+	if !d.pausedQueues[queue] {
+		return nil
+	}
+	delete(d.pausedQueues, queue)
+	return d.initDeliveryChannel(queue)
+}
+
+// IsSubscribed whether a broker consumer was registered on the queue, it is not cancelled when the connection is lost
+func (d *deliveryChannelManager) IsSubscribed(queue string) bool {
+	d.queueLocker.Lock()
+	defer d.queueLocker.Unlock()
+	_, ok := d.queueToConsumerTag[queue]
+	return ok
+}
+
+// Backlog messages ready in the broker queue, waiting for a consumer
+func (d *deliveryChannelManager) Backlog(queue string) (int, error) {
+	d.queueLocker.Lock()
+	_, ok := d.queueToDeliveryChan[queue]
+	d.queueLocker.Unlock()
+	if !ok {
+		return 0, ErrUnknownQueue
+	}
+	ampqChannel, err := d.connectionInitializer.GetAMQPChannel()
+	if err != nil {
+		return 0, err
+	}
+	state, err := ampqChannel.QueueInspect(queue)
+	if err != nil {
+		return 0, err
+	}
+	return state.Messages, nil
+}
+
+// IsPaused whether consumption of the queue is paused
+func (d *deliveryChannelManager) IsPaused(queue string) bool {
+	d.queueLocker.Lock()
+	defer d.queueLocker.Unlock()
+	return d.pausedQueues[queue]
+}
+
 func (d *deliveryChannelManager) NotifiedConnectionError() {
 	d.locker.Lock()
 	defer func() {
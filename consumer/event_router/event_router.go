@@ -0,0 +1,124 @@
+package event_router
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	eventbusclient "github.com/best-expendables/eventbus-client"
+	"github.com/best-expendables/eventbus-client/consumer/base_consumer"
+	"github.com/best-expendables/eventbus-client/consumer/consumer_middleware"
+	"github.com/best-expendables/eventbus-client/helper"
+)
+
+// ErrNoRoute the event name matches no route, set on the message by FallbackDeadLetter
+var ErrNoRoute = errors.New("no route for event")
+
+// FallbackPolicy what happens to events matching no route
+type FallbackPolicy int
+
+const (
+	// FallbackAck ack the message, it is logged and dropped
+	FallbackAck FallbackPolicy = iota
+	// FallbackReject reject the message, the broker dead-letter exchange of the queue applies
+	FallbackReject
+	// FallbackDeadLetter reject the message with ErrNoRoute, consumer_middleware.DeadLetter republishes it
+	FallbackDeadLetter
+)
+
+type route struct {
+	pattern string
+	handler consumer_middleware.ConsumeFunc
+}
+
+// EventRouter consumer dispatching the messages of a queue to handlers by event name.
+// An exact route wins over the longest matching prefix, which wins over the first matching wildcard pattern.
+// The queue middlewares set with Use run before routing, route middlewares run around their handler
+type EventRouter struct {
+	base_consumer.BaseConsumer
+	exact    map[string]*route
+	prefixes []*route
+	patterns []*route
+	fallback FallbackPolicy
+}
+
+var _ base_consumer.Consumer = (*EventRouter)(nil)
+
+func NewEventRouter() *EventRouter {
+	return &EventRouter{exact: map[string]*route{}}
+}
+
+// Handle route the event name to the handler
+func (r *EventRouter) Handle(eventName string, handler consumer_middleware.ConsumeFunc, middlewares ...consumer_middleware.Middleware) {
+	r.exact[eventName] = newRoute(eventName, handler, middlewares)
+}
+
+// HandlePrefix route the event names starting with prefix to the handler
+func (r *EventRouter) HandlePrefix(prefix string, handler consumer_middleware.ConsumeFunc, middlewares ...consumer_middleware.Middleware) {
+	r.prefixes = append(r.prefixes, newRoute(prefix, handler, middlewares))
+	sort.SliceStable(r.prefixes, func(i, j int) bool {
+		return len(r.prefixes[i].pattern) > len(r.prefixes[j].pattern)
+	})
+}
+
+// HandlePattern route the event names matching the path.Match pattern to the handler, e.g. `package_*`.
+// It panics when the pattern is malformed
+func (r *EventRouter) HandlePattern(pattern string, handler consumer_middleware.ConsumeFunc, middlewares ...consumer_middleware.Middleware) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		panic(fmt.Sprintf("event_router: invalid pattern %q: %s", pattern, err))
+	}
+	r.patterns = append(r.patterns, newRoute(pattern, handler, middlewares))
+}
+
+// SetFallback policy for events matching no route, FallbackAck by default
+func (r *EventRouter) SetFallback(policy FallbackPolicy) {
+	r.fallback = policy
+}
+
+func (r *EventRouter) Consume(ctx context.Context, message *eventbusclient.Message) {
+	if route := r.match(message.Header.EventName); route != nil {
+		route.handler(ctx, message)
+		return
+	}
+
+	fields := helper.GetLogFieldFromMessage(message)
+	helper.LoggerFromCtx(ctx).WithFields(fields).Infof("no route for event %q", message.Header.EventName)
+	switch r.fallback {
+	case FallbackReject:
+		message.Status = eventbusclient.MessageStatusReject
+	case FallbackDeadLetter:
+		message.Status = eventbusclient.MessageStatusReject
+		message.Error = fmt.Errorf("%w: %s", ErrNoRoute, message.Header.EventName)
+	default:
+		message.Status = eventbusclient.MessageStatusAck
+	}
+}
+
+func (r *EventRouter) match(eventName string) *route {
+	if route, ok := r.exact[eventName]; ok {
+		return route
+	}
+	for _, route := range r.prefixes {
+		if strings.HasPrefix(eventName, route.pattern) {
+			return route
+		}
+	}
+	for _, route := range r.patterns {
+		if matched, _ := path.Match(route.pattern, eventName); matched {
+			return route
+		}
+	}
+
+	return nil
+}
+
+func newRoute(pattern string, handler consumer_middleware.ConsumeFunc, middlewares []consumer_middleware.Middleware) *route {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+
+	return &route{pattern: pattern, handler: handler}
+}
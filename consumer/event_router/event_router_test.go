@@ -0,0 +1,112 @@
+package event_router
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	eventbusclient "github.com/best-expendables/eventbus-client"
+	"github.com/best-expendables/eventbus-client/consumer/consumer_middleware"
+)
+
+func newMessage(eventName string) *eventbusclient.Message {
+	return &eventbusclient.Message{
+		Header: eventbusclient.Header{EventName: eventName},
+		Status: eventbusclient.MessageStatusAck,
+	}
+}
+
+func TestEventRouter_Match(t *testing.T) {
+	var handled string
+	handler := func(name string) consumer_middleware.ConsumeFunc {
+		return func(ctx context.Context, message *eventbusclient.Message) {
+			handled = name
+		}
+	}
+
+	router := NewEventRouter()
+	router.HandlePattern("package_*", handler("pattern"))
+	router.HandlePrefix("package_", handler("short prefix"))
+	router.HandlePrefix("package_status_", handler("long prefix"))
+	router.Handle("package_creation", handler("exact"))
+
+	var cases = []struct {
+		eventName string
+		expect    string
+	}{
+		{eventName: "package_creation", expect: "exact"},
+		{eventName: "package_status_update", expect: "long prefix"},
+		{eventName: "package_update", expect: "short prefix"},
+		{eventName: "order_creation", expect: ""},
+	}
+	for _, c := range cases {
+		handled = ""
+		router.Consume(context.Background(), newMessage(c.eventName))
+		if handled != c.expect {
+			t.Errorf("event %s: expect %q route, got %q", c.eventName, c.expect, handled)
+		}
+	}
+
+	patternOnly := NewEventRouter()
+	patternOnly.HandlePattern("*_creation", handler("pattern"))
+	handled = ""
+	patternOnly.Consume(context.Background(), newMessage("order_creation"))
+	if handled != "pattern" {
+		t.Errorf("expect wildcard route, got %q", handled)
+	}
+}
+
+func TestEventRouter_RouteMiddlewares(t *testing.T) {
+	var calls []string
+	middleware := func(name string) consumer_middleware.Middleware {
+		return func(next consumer_middleware.ConsumeFunc) consumer_middleware.ConsumeFunc {
+			return func(ctx context.Context, message *eventbusclient.Message) {
+				calls = append(calls, name)
+				next(ctx, message)
+			}
+		}
+	}
+
+	router := NewEventRouter()
+	router.Handle("package_creation", func(ctx context.Context, message *eventbusclient.Message) {
+		calls = append(calls, "handler")
+	}, middleware("first"), middleware("second"))
+	router.Consume(context.Background(), newMessage("package_creation"))
+
+	if len(calls) != 3 || calls[0] != "first" || calls[1] != "second" || calls[2] != "handler" {
+		t.Errorf("wrong call order: %v", calls)
+	}
+}
+
+func TestEventRouter_Fallback(t *testing.T) {
+	router := NewEventRouter()
+
+	message := newMessage("unknown")
+	router.Consume(context.Background(), message)
+	if message.Status != eventbusclient.MessageStatusAck || message.Error != nil {
+		t.Errorf("expect unknown event to be acked by default, got %+v", message)
+	}
+
+	router.SetFallback(FallbackReject)
+	message = newMessage("unknown")
+	router.Consume(context.Background(), message)
+	if message.Status != eventbusclient.MessageStatusReject || message.Error != nil {
+		t.Errorf("expect unknown event to be rejected, got %+v", message)
+	}
+
+	router.SetFallback(FallbackDeadLetter)
+	message = newMessage("unknown")
+	router.Consume(context.Background(), message)
+	if message.Status != eventbusclient.MessageStatusReject || !errors.Is(message.Error, ErrNoRoute) {
+		t.Errorf("expect unknown event to be rejected with ErrNoRoute, got %+v", message)
+	}
+}
+
+func TestEventRouter_InvalidPattern(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expect malformed pattern to panic")
+		}
+	}()
+	NewEventRouter().HandlePattern("package_[", func(ctx context.Context, message *eventbusclient.Message) {})
+}
@@ -1,6 +1,7 @@
 package facade
 
 import (
+	"context"
 	"time"
 
 	"github.com/best-expendables/eventbus-client/consumer/base_consumer"
@@ -10,11 +11,33 @@ import (
 	"github.com/best-expendables/logger"
 )
 
+// Status state of the consumer connection and of its queues
+type Status struct {
+	// Connection one of the connection_initializer.ConnectionManagerStatus constants
+	Connection string
+	Queues     map[string]QueueStatus
+}
+
+// QueueStatus state of a consumed queue
+type QueueStatus struct {
+	consumer_manager.QueueStatus
+	Subscribed bool
+	Paused     bool
+}
+
 type ConsumerFacade interface {
 	AddQueueAndConsumer(queueName string, consumer base_consumer.Consumer, replication int)
+	SetCircuitBreaker(queueName string, config consumer_manager.CircuitBreakerConfig)
+	SetRateLimit(queueName string, config consumer_manager.RateLimitConfig)
 	Connect() error
 	StartConsuming(queueNames ...string) error
-	ShutDown() error
+	ScaleQueue(queueName string, workers int) error
+	AutoscaleQueue(queueName string, config consumer_manager.AutoscaleConfig) error
+	Workers(queueName string) int
+	Pause(queueName string) error
+	Resume(queueName string) error
+	Status() Status
+	ShutDown(ctx context.Context) error
 	Wait()
 }
 
@@ -45,6 +68,14 @@ func (c *consumerFacade) AddQueueAndConsumer(queueName string, consumer base_con
 	c.consumerManager.AssignConsumerToQueue(queueName, consumer, replication)
 }
 
+func (c *consumerFacade) SetCircuitBreaker(queueName string, config consumer_manager.CircuitBreakerConfig) {
+	c.consumerManager.SetCircuitBreaker(queueName, config)
+}
+
+func (c *consumerFacade) SetRateLimit(queueName string, config consumer_manager.RateLimitConfig) {
+	c.consumerManager.SetRateLimit(queueName, config)
+}
+
 func (c *consumerFacade) Connect() error {
 	if err := c.connectionInitializer.Connect(); err != nil {
 		return err
@@ -68,6 +99,56 @@ func (c *consumerFacade) StartConsuming(queueNames ...string) error {
 	return nil
 }
 
+// ScaleQueue set the number of workers of a consumed queue, stopped workers finish their current message
+func (c *consumerFacade) ScaleQueue(queueName string, workers int) error {
+	return c.consumerManager.ScaleQueue(queueName, workers)
+}
+
+// AutoscaleQueue let the number of workers of a consumed queue follow its load, see consumer_manager.AutoscaleConfig
+func (c *consumerFacade) AutoscaleQueue(queueName string, config consumer_manager.AutoscaleConfig) error {
+	return c.consumerManager.AutoscaleQueue(queueName, config)
+}
+
+// Workers number of workers consuming the queue
+func (c *consumerFacade) Workers(queueName string) int {
+	return c.consumerManager.Workers(queueName)
+}
+
+// Pause stop consuming a queue without affecting the others, messages already received are still consumed.
+// The queue stays paused across reconnects until Resume
+func (c *consumerFacade) Pause(queueName string) error {
+	if err := c.deliveryChannelManager.Pause(queueName); err != nil {
+		return err
+	}
+	logger.Infof("consumption of queue %s paused", queueName)
+	return nil
+}
+
+// Resume consuming a paused queue
+func (c *consumerFacade) Resume(queueName string) error {
+	if err := c.deliveryChannelManager.Resume(queueName); err != nil {
+		return err
+	}
+	logger.Infof("consumption of queue %s resumed", queueName)
+	return nil
+}
+
+// Status state of the connection and of the consumed queues, e.g. for health checks
+func (c *consumerFacade) Status() Status {
+	status := Status{
+		Connection: c.connectionInitializer.Status(),
+		Queues:     map[string]QueueStatus{},
+	}
+	for queueName, queueStatus := range c.consumerManager.Status() {
+		status.Queues[queueName] = QueueStatus{
+			QueueStatus: queueStatus,
+			Subscribed:  c.deliveryChannelManager.IsSubscribed(queueName),
+			Paused:      c.deliveryChannelManager.IsPaused(queueName),
+		}
+	}
+	return status
+}
+
 func (c *consumerFacade) regainConnection(notifierChan <-chan bool) {
 	for {
 		select {
@@ -96,8 +177,17 @@ func (c *consumerFacade) regainConnection(notifierChan <-chan bool) {
 	}
 }
 
-func (c *consumerFacade) ShutDown() error {
-	c.consumerManager.ShutDown()
+// ShutDown cancel the broker consumers, then wait for in flight messages to be consumed and acked until ctx is done.
+// A *consumer_manager.ShutdownError reports the messages abandoned at the deadline, the broker redelivers them
+func (c *consumerFacade) ShutDown(ctx context.Context) error {
+	if err := c.deliveryChannelManager.CancelConsumers(); err != nil {
+		logger.Infof("consumers cancel failed, reason: %s", err)
+	}
+
+	err := c.consumerManager.ShutDown(ctx)
+	if err != nil {
+		logger.Errorf("consumer manager shutdown incomplete: %s", err)
+	}
 
 	c.deliveryChannelManager.Close()
 
@@ -105,7 +195,7 @@ func (c *consumerFacade) ShutDown() error {
 		logger.Infof("connection initializer failed to disconnect, reason: %s", err)
 	}
 	close(c.doneChan)
-	return nil
+	return err
 }
 
 func (c *consumerFacade) Wait() {
@@ -0,0 +1,682 @@
+package facade
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	eventbusclient "github.com/best-expendables/eventbus-client"
+	"github.com/best-expendables/eventbus-client/consumer/base_consumer"
+	"github.com/best-expendables/eventbus-client/consumer/connection_initializer"
+	"github.com/best-expendables/eventbus-client/consumer/consumer_manager"
+	"github.com/best-expendables/eventbus-client/consumer/delivery_channel_manager"
+	"github.com/best-expendables/eventbus-client/producer_manager"
+	"github.com/best-expendables/eventbus-client/transport"
+	"github.com/streadway/amqp"
+)
+
+const testQueue = "package_creation"
+
+type channelConsumer struct {
+	base_consumer.BaseConsumer
+	received chan *eventbusclient.Message
+}
+
+func (c *channelConsumer) Consume(_ context.Context, message *eventbusclient.Message) {
+	c.received <- message
+}
+
+func newTestConfig(broker *transport.MemoryBroker) *eventbusclient.Config {
+	return &eventbusclient.Config{
+		PrefectCount: 10,
+		Transport:    broker,
+		Topology:     &eventbusclient.Topology{Queues: []eventbusclient.Queue{{Name: testQueue, Durable: true}}},
+	}
+}
+
+func newTestFacade(config *eventbusclient.Config) ConsumerFacade {
+	connectionInitializer := connection_initializer.NewConnectionInitializer(config)
+	deliveryChannelManager := delivery_channel_manager.NewDeliveryChannelManager(connectionInitializer)
+	consumerManager := consumer_manager.NewConsumerManager(deliveryChannelManager)
+
+	return NewConsumerFacade(connectionInitializer, deliveryChannelManager, consumerManager)
+}
+
+func publish(t *testing.T, p producer_manager.Producer, id string) {
+	err := p.Publish(context.Background(), &eventbusclient.Message{
+		Id:         id,
+		RoutingKey: testQueue,
+		Header: eventbusclient.Header{
+			Timestamp: time.Now(),
+			Publisher: "package",
+			EventName: "package_creation",
+		},
+		Payload: eventbusclient.Payload{EntityId: id, Data: map[string]interface{}{"id": id}},
+	})
+	if err != nil {
+		t.Fatalf("publish failed: %s", err)
+	}
+}
+
+func expectMessage(t *testing.T, received <-chan *eventbusclient.Message, id string) {
+	select {
+	case msg := <-received:
+		if msg.Id != id || msg.Payload.EntityId != id || msg.Header.EventName != "package_creation" {
+			t.Errorf("wrong message received: %+v", msg)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatalf("message %s not consumed", id)
+	}
+}
+
+func TestConsumerFacade_EndToEnd(t *testing.T) {
+	broker := transport.NewMemoryBroker()
+	config := newTestConfig(broker)
+
+	f := newTestFacade(config)
+	c := &channelConsumer{received: make(chan *eventbusclient.Message, 10)}
+	f.AddQueueAndConsumer(testQueue, c, 2)
+	if err := f.Connect(); err != nil {
+		t.Fatalf("connect failed: %s", err)
+	}
+	if err := f.StartConsuming(); err != nil {
+		t.Fatalf("start consuming failed: %s", err)
+	}
+
+	p, err := producer_manager.NewProducerWithConfig(config)
+	if err != nil {
+		t.Fatalf("create producer: %s", err)
+	}
+	defer p.Close()
+
+	publish(t, p, "1")
+	expectMessage(t, c.received, "1")
+
+	broker.DropConnections()
+
+	publish(t, p, "2")
+	expectMessage(t, c.received, "2")
+
+	if err := f.ShutDown(context.Background()); err != nil {
+		t.Errorf("shutdown failed: %s", err)
+	}
+	if broker.MessageCount(testQueue) != 0 {
+		t.Error("expect every message to be acked")
+	}
+}
+
+func TestConsumerFacade_InvalidPayloadIsAcked(t *testing.T) {
+	broker := transport.NewMemoryBroker()
+	config := newTestConfig(broker)
+
+	f := newTestFacade(config)
+	c := &channelConsumer{received: make(chan *eventbusclient.Message, 10)}
+	f.AddQueueAndConsumer(testQueue, c, 1)
+	if err := f.Connect(); err != nil {
+		t.Fatalf("connect failed: %s", err)
+	}
+	if err := f.StartConsuming(); err != nil {
+		t.Fatalf("start consuming failed: %s", err)
+	}
+	defer f.ShutDown(context.Background())
+
+	conn, _ := broker.Dial("")
+	ch, _ := conn.Channel()
+	_ = ch.Publish("", testQueue, false, false, amqp.Publishing{Body: []byte("not json")})
+
+	deadline := time.Now().Add(time.Second)
+	for broker.MessageCount(testQueue)+broker.UnackedCount(testQueue) != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if broker.UnackedCount(testQueue) != 0 {
+		t.Error("expect invalid payload to be acked")
+	}
+	select {
+	case msg := <-c.received:
+		t.Errorf("invalid payload must not reach the consumer, got: %+v", msg)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+type slowConsumer struct {
+	base_consumer.BaseConsumer
+	started chan struct{}
+	once    sync.Once
+	delay   time.Duration
+}
+
+func (c *slowConsumer) Consume(_ context.Context, _ *eventbusclient.Message) {
+	c.once.Do(func() { close(c.started) })
+	time.Sleep(c.delay)
+}
+
+func startSlowConsumer(t *testing.T, broker *transport.MemoryBroker, delay time.Duration) (ConsumerFacade, *slowConsumer) {
+	config := newTestConfig(broker)
+	f := newTestFacade(config)
+	c := &slowConsumer{started: make(chan struct{}), delay: delay}
+	f.AddQueueAndConsumer(testQueue, c, 1)
+	if err := f.Connect(); err != nil {
+		t.Fatalf("connect failed: %s", err)
+	}
+	if err := f.StartConsuming(); err != nil {
+		t.Fatalf("start consuming failed: %s", err)
+	}
+
+	p, err := producer_manager.NewProducerWithConfig(config)
+	if err != nil {
+		t.Fatalf("create producer: %s", err)
+	}
+	defer p.Close()
+	publish(t, p, "1")
+	publish(t, p, "2")
+
+	select {
+	case <-c.started:
+	case <-time.After(time.Second):
+		t.Fatal("message not consumed")
+	}
+	return f, c
+}
+
+func TestConsumerFacade_ShutDownDrainsInFlight(t *testing.T) {
+	broker := transport.NewMemoryBroker()
+	f, _ := startSlowConsumer(t, broker, 100*time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := f.ShutDown(ctx); err != nil {
+		t.Fatalf("shutdown failed: %s", err)
+	}
+
+	// the in flight message is acked, the other one goes back to the queue
+	if broker.MessageCount(testQueue) != 1 || broker.UnackedCount(testQueue) != 0 {
+		t.Errorf("expect 1 message left in queue, got %d ready and %d unacked",
+			broker.MessageCount(testQueue), broker.UnackedCount(testQueue))
+	}
+}
+
+func TestConsumerFacade_ShutDownDeadline(t *testing.T) {
+	broker := transport.NewMemoryBroker()
+	f, _ := startSlowConsumer(t, broker, time.Second)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := f.ShutDown(ctx)
+
+	shutdownErr, ok := err.(*consumer_manager.ShutdownError)
+	if !ok {
+		t.Fatalf("expect ShutdownError, got: %v", err)
+	}
+	if shutdownErr.Abandoned != 1 || shutdownErr.Err != context.DeadlineExceeded {
+		t.Errorf("wrong shutdown error: %s", err)
+	}
+	if broker.MessageCount(testQueue) != 2 {
+		t.Errorf("expect both messages back in queue, got %d", broker.MessageCount(testQueue))
+	}
+}
+
+type failingConsumer struct {
+	base_consumer.BaseConsumer
+	consumed int32
+}
+
+func (c *failingConsumer) Consume(_ context.Context, message *eventbusclient.Message) {
+	atomic.AddInt32(&c.consumed, 1)
+	message.Error = errors.New("database is down")
+}
+
+func TestConsumerFacade_CircuitBreaker(t *testing.T) {
+	broker := transport.NewMemoryBroker()
+	config := newTestConfig(broker)
+
+	f := newTestFacade(config)
+	c := &failingConsumer{}
+	opened := make(chan struct{})
+	f.AddQueueAndConsumer(testQueue, c, 1)
+	f.SetCircuitBreaker(testQueue, consumer_manager.CircuitBreakerConfig{
+		Window:       4,
+		OpenDuration: time.Hour,
+		OnStateChange: func(queue string, from, to consumer_manager.BreakerState) {
+			if to == consumer_manager.BreakerOpen {
+				close(opened)
+			}
+		},
+	})
+	if err := f.Connect(); err != nil {
+		t.Fatalf("connect failed: %s", err)
+	}
+	defer f.ShutDown(context.Background())
+
+	p, err := producer_manager.NewProducerWithConfig(config)
+	if err != nil {
+		t.Fatalf("create producer: %s", err)
+	}
+	defer p.Close()
+	for i := 0; i < 10; i++ {
+		publish(t, p, fmt.Sprintf("%d", i))
+	}
+	if err := f.StartConsuming(); err != nil {
+		t.Fatalf("start consuming failed: %s", err)
+	}
+
+	select {
+	case <-opened:
+	case <-time.After(3 * time.Second):
+		t.Fatal("expect the breaker to open")
+	}
+	time.Sleep(100 * time.Millisecond)
+	if consumed := atomic.LoadInt32(&c.consumed); consumed != 4 {
+		t.Errorf("expect consumption to stop once the breaker opened, consumed %d", consumed)
+	}
+	if pending := broker.MessageCount(testQueue) + broker.UnackedCount(testQueue); pending != 6 {
+		t.Errorf("expect the other messages left with the broker, got %d", pending)
+	}
+}
+
+func TestConsumerFacade_CircuitBreakerIgnoresUndecodable(t *testing.T) {
+	broker := transport.NewMemoryBroker()
+	config := newTestConfig(broker)
+
+	f := newTestFacade(config)
+	c := &failingConsumer{}
+	var opened int32
+	f.AddQueueAndConsumer(testQueue, c, 1)
+	f.SetCircuitBreaker(testQueue, consumer_manager.CircuitBreakerConfig{
+		Window:       2,
+		OpenDuration: time.Hour,
+		OnStateChange: func(queue string, from, to consumer_manager.BreakerState) {
+			if to == consumer_manager.BreakerOpen {
+				atomic.StoreInt32(&opened, 1)
+			}
+		},
+	})
+	if err := f.Connect(); err != nil {
+		t.Fatalf("connect failed: %s", err)
+	}
+	if err := f.StartConsuming(); err != nil {
+		t.Fatalf("start consuming failed: %s", err)
+	}
+	defer f.ShutDown(context.Background())
+
+	conn, _ := broker.Dial("")
+	ch, _ := conn.Channel()
+	for i := 0; i < 4; i++ {
+		_ = ch.Publish("", testQueue, false, false, amqp.Publishing{ContentType: eventbusclient.ContentTypeJSON, Body: []byte("{")})
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for broker.MessageCount(testQueue)+broker.UnackedCount(testQueue) > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if pending := broker.MessageCount(testQueue) + broker.UnackedCount(testQueue); pending != 0 {
+		t.Fatalf("expect the invalid messages acked, %d left", pending)
+	}
+	if atomic.LoadInt32(&opened) != 0 {
+		t.Error("expect undecodable deliveries not to open the breaker")
+	}
+}
+
+type blockingConsumer struct {
+	base_consumer.BaseConsumer
+	started chan string
+	release chan struct{}
+}
+
+func (c *blockingConsumer) Consume(_ context.Context, message *eventbusclient.Message) {
+	c.started <- message.Id
+	<-c.release
+}
+
+func TestConsumerFacade_ScaleQueue(t *testing.T) {
+	broker := transport.NewMemoryBroker()
+	config := newTestConfig(broker)
+
+	f := newTestFacade(config)
+	c := &blockingConsumer{started: make(chan string, 10), release: make(chan struct{})}
+	f.AddQueueAndConsumer(testQueue, c, 1)
+	if err := f.Connect(); err != nil {
+		t.Fatalf("connect failed: %s", err)
+	}
+	if err := f.StartConsuming(); err != nil {
+		t.Fatalf("start consuming failed: %s", err)
+	}
+
+	p, err := producer_manager.NewProducerWithConfig(config)
+	if err != nil {
+		t.Fatalf("create producer: %s", err)
+	}
+	defer p.Close()
+	for i := 0; i < 4; i++ {
+		publish(t, p, fmt.Sprintf("%d", i))
+	}
+
+	expectStarted := func(count int) {
+		for i := 0; i < count; i++ {
+			select {
+			case <-c.started:
+			case <-time.After(time.Second):
+				t.Fatalf("expect %d messages consumed at once, got %d", count, i)
+			}
+		}
+		select {
+		case id := <-c.started:
+			t.Fatalf("expect %d messages consumed at once, message %s too", count, id)
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+	expectStarted(1)
+
+	if err := f.ScaleQueue(testQueue, 3); err != nil {
+		t.Fatalf("scale up failed: %s", err)
+	}
+	if f.Workers(testQueue) != 3 {
+		t.Errorf("expect 3 workers, got %d", f.Workers(testQueue))
+	}
+	expectStarted(2)
+
+	// stopped workers finish their message
+	if err := f.ScaleQueue(testQueue, 1); err != nil {
+		t.Fatalf("scale down failed: %s", err)
+	}
+	for i := 0; i < 3; i++ {
+		c.release <- struct{}{}
+	}
+	expectStarted(1)
+	c.release <- struct{}{}
+
+	if err := f.ShutDown(context.Background()); err != nil {
+		t.Fatalf("shutdown failed: %s", err)
+	}
+	if pending := broker.MessageCount(testQueue) + broker.UnackedCount(testQueue); pending != 0 {
+		t.Errorf("expect every message acked, %d left", pending)
+	}
+	if err := f.ScaleQueue(testQueue, 2); err != consumer_manager.ErrManagerShutDown {
+		t.Errorf("expect ErrManagerShutDown, got: %v", err)
+	}
+}
+
+func TestConsumerFacade_PauseResume(t *testing.T) {
+	const otherQueue = "package_status_update"
+	broker := transport.NewMemoryBroker()
+	config := newTestConfig(broker)
+	config.Topology.Queues = append(config.Topology.Queues, eventbusclient.Queue{Name: otherQueue, Durable: true})
+
+	f := newTestFacade(config)
+	c := &channelConsumer{received: make(chan *eventbusclient.Message, 10)}
+	other := &channelConsumer{received: make(chan *eventbusclient.Message, 10)}
+	f.AddQueueAndConsumer(testQueue, c, 1)
+	f.AddQueueAndConsumer(otherQueue, other, 1)
+	if err := f.Connect(); err != nil {
+		t.Fatalf("connect failed: %s", err)
+	}
+	if err := f.StartConsuming(); err != nil {
+		t.Fatalf("start consuming failed: %s", err)
+	}
+	defer f.ShutDown(context.Background())
+
+	p, err := producer_manager.NewProducerWithConfig(config)
+	if err != nil {
+		t.Fatalf("create producer: %s", err)
+	}
+	defer p.Close()
+	publishTo := func(queue, id string) {
+		err := p.Publish(context.Background(), &eventbusclient.Message{
+			Id:         id,
+			RoutingKey: queue,
+			Header:     eventbusclient.Header{Timestamp: time.Now(), Publisher: "package", EventName: "package_creation"},
+			Payload:    eventbusclient.Payload{EntityId: id, Data: map[string]interface{}{"id": id}},
+		})
+		if err != nil {
+			t.Fatalf("publish failed: %s", err)
+		}
+	}
+	waitConsumers := func(queue string, count int) {
+		deadline := time.Now().Add(3 * time.Second)
+		for broker.ConsumerCount(queue) != count && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if broker.ConsumerCount(queue) != count {
+			t.Fatalf("expect %d consumer(s) on %s, got %d", count, queue, broker.ConsumerCount(queue))
+		}
+	}
+
+	if err := f.Pause(testQueue); err != nil {
+		t.Fatalf("pause failed: %s", err)
+	}
+	waitConsumers(testQueue, 0)
+	publishTo(testQueue, "1")
+	publishTo(otherQueue, "2")
+	expectMessage(t, other.received, "2")
+
+	broker.DropConnections()
+	waitConsumers(otherQueue, 1)
+	publishTo(otherQueue, "3")
+	expectMessage(t, other.received, "3")
+	if broker.ConsumerCount(testQueue) != 0 || broker.MessageCount(testQueue) != 1 {
+		t.Fatalf("expect the queue to stay paused after reconnect")
+	}
+	select {
+	case msg := <-c.received:
+		t.Fatalf("paused queue must not be consumed, got: %+v", msg)
+	default:
+	}
+
+	if err := f.Resume(testQueue); err != nil {
+		t.Fatalf("resume failed: %s", err)
+	}
+	expectMessage(t, c.received, "1")
+
+	if err := f.Pause("unknown"); err != delivery_channel_manager.ErrUnknownQueue {
+		t.Errorf("expect ErrUnknownQueue, got: %v", err)
+	}
+}
+
+func TestConsumerFacade_Status(t *testing.T) {
+	broker := transport.NewMemoryBroker()
+	config := newTestConfig(broker)
+
+	f := newTestFacade(config)
+	c := &channelConsumer{received: make(chan *eventbusclient.Message, 10)}
+	f.AddQueueAndConsumer(testQueue, c, 2)
+	if err := f.Connect(); err != nil {
+		t.Fatalf("connect failed: %s", err)
+	}
+	if err := f.StartConsuming(); err != nil {
+		t.Fatalf("start consuming failed: %s", err)
+	}
+	defer f.ShutDown(context.Background())
+
+	p, err := producer_manager.NewProducerWithConfig(config)
+	if err != nil {
+		t.Fatalf("create producer: %s", err)
+	}
+	defer p.Close()
+	publish(t, p, "1")
+	expectMessage(t, c.received, "1")
+
+	deadline := time.Now().Add(time.Second)
+	for f.Status().Queues[testQueue].LastAck.IsZero() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	status := f.Status()
+	queue := status.Queues[testQueue]
+	if status.Connection != connection_initializer.ConnectionManagerStatusConnected || !p.Connected() {
+		t.Errorf("expect connected, got consumer %s", status.Connection)
+	}
+	if !queue.Subscribed || queue.Paused || queue.Workers != 2 || queue.InFlight != 0 || queue.LastAck.IsZero() || !queue.WaitingSince.IsZero() {
+		t.Errorf("wrong queue status: %+v", queue)
+	}
+
+	if err := f.Pause(testQueue); err != nil {
+		t.Fatalf("pause failed: %s", err)
+	}
+	if queue := f.Status().Queues[testQueue]; queue.Subscribed || !queue.Paused {
+		t.Errorf("expect paused queue, got: %+v", queue)
+	}
+}
+
+func TestConsumerFacade_UnsupportedContentTypeIsRejected(t *testing.T) {
+	broker := transport.NewMemoryBroker()
+	config := newTestConfig(broker)
+	config.Topology.Queues[0].DeadLetterQueue = true
+	config.Topology.Queues[0].DeadLetterRoutingKey = eventbusclient.DeadLetterName(testQueue)
+
+	f := newTestFacade(config)
+	c := &channelConsumer{received: make(chan *eventbusclient.Message, 10)}
+	f.AddQueueAndConsumer(testQueue, c, 1)
+	if err := f.Connect(); err != nil {
+		t.Fatalf("connect failed: %s", err)
+	}
+	if err := f.StartConsuming(); err != nil {
+		t.Fatalf("start consuming failed: %s", err)
+	}
+	defer f.ShutDown(context.Background())
+
+	conn, _ := broker.Dial("")
+	ch, _ := conn.Channel()
+	_ = ch.Publish("", testQueue, false, false, amqp.Publishing{ContentType: "application/x-unknown", Body: []byte{0x01}})
+
+	dead := eventbusclient.DeadLetterName(testQueue)
+	deadline := time.Now().Add(time.Second)
+	for broker.MessageCount(dead) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if broker.MessageCount(dead) != 1 {
+		t.Error("expect the message rejected to the dead-letter exchange")
+	}
+	select {
+	case msg := <-c.received:
+		t.Errorf("undecodable message must not reach the consumer, got: %+v", msg)
+	default:
+	}
+}
+
+func TestConsumerFacade_OversizedBodyIsRejected(t *testing.T) {
+	broker := transport.NewMemoryBroker()
+	config := newTestConfig(broker)
+	config.Topology.Queues[0].DeadLetterQueue = true
+	config.Topology.Queues[0].DeadLetterRoutingKey = eventbusclient.DeadLetterName(testQueue)
+
+	f := newTestFacade(config)
+	c := &channelConsumer{received: make(chan *eventbusclient.Message, 10)}
+	f.AddQueueAndConsumer(testQueue, c, 1)
+	if err := f.Connect(); err != nil {
+		t.Fatalf("connect failed: %s", err)
+	}
+	if err := f.StartConsuming(); err != nil {
+		t.Fatalf("start consuming failed: %s", err)
+	}
+	defer f.ShutDown(context.Background())
+
+	bomb, err := (eventbusclient.GzipCompressor{}).Compress(make([]byte, eventbusclient.DefaultMaxDecompressedSize+1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, _ := broker.Dial("")
+	ch, _ := conn.Channel()
+	_ = ch.Publish("", testQueue, false, false, amqp.Publishing{
+		ContentType:     eventbusclient.ContentTypeJSON,
+		ContentEncoding: eventbusclient.ContentEncodingGzip,
+		Body:            bomb,
+	})
+
+	dead := eventbusclient.DeadLetterName(testQueue)
+	deadline := time.Now().Add(5 * time.Second)
+	for broker.MessageCount(dead) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if broker.MessageCount(dead) != 1 {
+		t.Error("expect the message rejected to the dead-letter exchange")
+	}
+	select {
+	case msg := <-c.received:
+		t.Errorf("oversized message must not reach the consumer, got: %+v", msg)
+	default:
+	}
+}
+
+func TestConsumerFacade_AutoscaleOnBacklog(t *testing.T) {
+	broker := transport.NewMemoryBroker()
+	config := newTestConfig(broker)
+	config.PrefectCount = 1
+
+	f := newTestFacade(config)
+	c := &blockingConsumer{started: make(chan string, 10), release: make(chan struct{})}
+	f.AddQueueAndConsumer(testQueue, c, 1)
+	if err := f.Connect(); err != nil {
+		t.Fatalf("connect failed: %s", err)
+	}
+	if err := f.StartConsuming(); err != nil {
+		t.Fatalf("start consuming failed: %s", err)
+	}
+
+	p, err := producer_manager.NewProducerWithConfig(config)
+	if err != nil {
+		t.Fatalf("create producer: %s", err)
+	}
+	defer p.Close()
+	for i := 0; i < 8; i++ {
+		publish(t, p, fmt.Sprintf("%d", i))
+	}
+
+	// the busy ratio alone never adds a worker
+	autoscale := consumer_manager.AutoscaleConfig{Min: 1, Max: 3, Interval: 20 * time.Millisecond, ScaleUpBusyRatio: 2, ScaleUpBacklog: 1}
+	if err := f.AutoscaleQueue(testQueue, autoscale); err != nil {
+		t.Fatalf("autoscale failed: %s", err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for f.Workers(testQueue) < 3 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if f.Workers(testQueue) != 3 {
+		t.Errorf("expect workers added while messages wait in the queue, got %d", f.Workers(testQueue))
+	}
+
+	close(c.release)
+	if err := f.ShutDown(context.Background()); err != nil {
+		t.Fatalf("shutdown failed: %s", err)
+	}
+}
+
+func TestConsumerFacade_AutoscaleWithRateLimit(t *testing.T) {
+	broker := transport.NewMemoryBroker()
+	config := newTestConfig(broker)
+
+	f := newTestFacade(config)
+	c := &channelConsumer{received: make(chan *eventbusclient.Message, 100)}
+	f.AddQueueAndConsumer(testQueue, c, 1)
+	f.SetRateLimit(testQueue, consumer_manager.RateLimitConfig{Limit: consumer_manager.RateLimit{Rate: 20}})
+	if err := f.Connect(); err != nil {
+		t.Fatalf("connect failed: %s", err)
+	}
+	if err := f.StartConsuming(); err != nil {
+		t.Fatalf("start consuming failed: %s", err)
+	}
+	defer f.ShutDown(context.Background())
+
+	p, err := producer_manager.NewProducerWithConfig(config)
+	if err != nil {
+		t.Fatalf("create producer: %s", err)
+	}
+	defer p.Close()
+	for i := 0; i < 30; i++ {
+		publish(t, p, fmt.Sprintf("%d", i))
+	}
+
+	// the workers wait for the rate limit, more of them would not consume faster
+	autoscale := consumer_manager.AutoscaleConfig{Min: 1, Max: 3, Interval: 50 * time.Millisecond}
+	if err := f.AutoscaleQueue(testQueue, autoscale); err != nil {
+		t.Fatalf("autoscale failed: %s", err)
+	}
+	time.Sleep(500 * time.Millisecond)
+	if workers := f.Workers(testQueue); workers != 1 {
+		t.Errorf("expect the rate limit wait not counted as busy, got %d workers", workers)
+	}
+	if len(c.received) == 0 {
+		t.Error("expect messages consumed under the rate limit")
+	}
+}
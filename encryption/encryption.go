@@ -0,0 +1,212 @@
+package encryption
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+
+	eventbusclient "github.com/best-expendables/eventbus-client"
+	"github.com/best-expendables/eventbus-client/consumer/consumer_middleware"
+	"github.com/best-expendables/eventbus-client/helper"
+	"github.com/best-expendables/eventbus-client/producer_manager"
+)
+
+// Headers of an encrypted message, the payload data is replaced by the ciphertext
+const (
+	KeyIdHeader       = eventbusclient.EncryptionKeyIdHeader
+	WrappedKeyHeader  = "x-encryption-key"
+	AlgorithmHeader   = "x-encryption-alg"
+	ContentTypeHeader = "x-encryption-content-type"
+)
+
+// Algorithm encrypting the payload data with the data key
+const Algorithm = "AES-256-GCM"
+
+const dataKeySize = 32
+
+// ErrCiphertext the ciphertext is malformed or was tampered with
+var ErrCiphertext = errors.New("payload ciphertext is invalid")
+
+// KeyProvider wrap the data key of each message with a named master key.
+// Keep retired master keys able to unwrap until no message encrypted with them is left in the queues
+type KeyProvider interface {
+	// CurrentKeyID master key wrapping the data keys of new messages
+	CurrentKeyID() string
+	WrapKey(ctx context.Context, keyID string, dataKey []byte) ([]byte, error)
+	UnwrapKey(ctx context.Context, keyID string, wrapped []byte) ([]byte, error)
+}
+
+// DecryptError the payload of a message could not be decrypted
+type DecryptError struct {
+	KeyID string
+	Err   error
+}
+
+func (e *DecryptError) Error() string {
+	return fmt.Sprintf("decrypt payload with key %q: %s", e.KeyID, e.Err)
+}
+
+func (e *DecryptError) Unwrap() error {
+	return e.Err
+}
+
+// PublishMiddleware encrypt the payload data with a new data key, wrapped by the current master key.
+// The entity id and headers stay readable. Add it before validating middlewares so they see the clear data
+func PublishMiddleware(provider KeyProvider) producer_manager.PublishFuncMiddleware {
+	return func(next producer_manager.PublishFunc) producer_manager.PublishFunc {
+		return func(ctx context.Context, message *eventbusclient.Message) error {
+			encrypted, err := encrypt(ctx, provider, message)
+			if err != nil {
+				return err
+			}
+			return next(ctx, encrypted)
+		}
+	}
+}
+
+// ConsumeMiddleware decrypt the payload data of encrypted messages, others are left untouched.
+// Messages failing to decrypt are rejected with a *DecryptError. Use it before the middlewares reading the data
+func ConsumeMiddleware(provider KeyProvider) consumer_middleware.Middleware {
+	return func(next consumer_middleware.ConsumeFunc) consumer_middleware.ConsumeFunc {
+		return func(ctx context.Context, message *eventbusclient.Message) {
+			if _, ok := message.Header.Extra[KeyIdHeader]; !ok {
+				next(ctx, message)
+				return
+			}
+			if err := decrypt(ctx, provider, message); err != nil {
+				helper.LoggerFromCtx(ctx).WithFields(helper.GetLogFieldFromMessage(message)).Errorf("MessageDecryptFailed: %s", err)
+				message.Error = err
+				message.Status = eventbusclient.MessageStatusReject
+				return
+			}
+			next(ctx, message)
+		}
+	}
+}
+
+// encrypt a copy of the message, the caller's one keeps its clear data
+func encrypt(ctx context.Context, provider KeyProvider, message *eventbusclient.Message) (*eventbusclient.Message, error) {
+	codec, err := eventbusclient.LookupCodec(message.ContentType)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, encoded := message.Payload.Data.(eventbusclient.EncodedData)
+	if !encoded {
+		if plaintext, err = codec.Marshal(message.Payload.Data); err != nil {
+			return nil, err
+		}
+	}
+
+	dataKey := make([]byte, dataKeySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return nil, err
+	}
+	keyID := provider.CurrentKeyID()
+	wrapped, err := provider.WrapKey(ctx, keyID, dataKey)
+	if err != nil {
+		return nil, err
+	}
+	ciphertext, err := seal(dataKey, plaintext, additionalData(message))
+	if err != nil {
+		return nil, err
+	}
+
+	encrypted := *message
+	encrypted.ContentType = eventbusclient.ContentTypeJSON
+	encrypted.Payload = eventbusclient.Payload{EntityId: message.Payload.EntityId, Data: eventbusclient.EncodedData(ciphertext)}
+	encrypted.Header.Extra = map[string]interface{}{}
+	for key, value := range message.Header.Extra {
+		encrypted.Header.Extra[key] = value
+	}
+	encrypted.Header.SetExtra(KeyIdHeader, keyID)
+	encrypted.Header.SetExtra(WrappedKeyHeader, base64.StdEncoding.EncodeToString(wrapped))
+	encrypted.Header.SetExtra(AlgorithmHeader, Algorithm)
+	encrypted.Header.SetExtra(ContentTypeHeader, codec.ContentType())
+
+	return &encrypted, nil
+}
+
+func decrypt(ctx context.Context, provider KeyProvider, message *eventbusclient.Message) error {
+	keyID := fmt.Sprintf("%v", message.Header.Extra[KeyIdHeader])
+	decryptErr := func(err error) error {
+		return &DecryptError{KeyID: keyID, Err: err}
+	}
+
+	if algorithm := fmt.Sprintf("%v", message.Header.Extra[AlgorithmHeader]); algorithm != Algorithm {
+		return decryptErr(fmt.Errorf("unsupported algorithm %q", algorithm))
+	}
+	wrapped, err := base64.StdEncoding.DecodeString(fmt.Sprintf("%v", message.Header.Extra[WrappedKeyHeader]))
+	if err != nil {
+		return decryptErr(err)
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(fmt.Sprintf("%v", message.Payload.Data))
+	if err != nil {
+		return decryptErr(ErrCiphertext)
+	}
+
+	dataKey, err := provider.UnwrapKey(ctx, keyID, wrapped)
+	if err != nil {
+		return decryptErr(err)
+	}
+	plaintext, err := open(dataKey, ciphertext, additionalData(message))
+	if err != nil {
+		return decryptErr(err)
+	}
+
+	contentType := fmt.Sprintf("%v", message.Header.Extra[ContentTypeHeader])
+	if err := message.Payload.DecodeData(contentType, plaintext); err != nil {
+		return decryptErr(err)
+	}
+	message.ContentType = contentType
+	for _, key := range []string{KeyIdHeader, WrappedKeyHeader, AlgorithmHeader, ContentTypeHeader} {
+		delete(message.Header.Extra, key)
+	}
+
+	return nil
+}
+
+// additionalData bind the ciphertext to its message, it can not be replayed in another one
+func additionalData(message *eventbusclient.Message) []byte {
+	return []byte(message.Id + "\x00" + message.Header.EventName)
+}
+
+// seal encrypt with AES-GCM, the random nonce prefixes the ciphertext
+func seal(key, plaintext, additionalData []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func open(key, ciphertext, additionalData []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, ErrCiphertext
+	}
+	plaintext, err := aead.Open(nil, ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():], additionalData)
+	if err != nil {
+		return nil, ErrCiphertext
+	}
+	return plaintext, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
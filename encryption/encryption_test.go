@@ -0,0 +1,152 @@
+package encryption
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	eventbusclient "github.com/best-expendables/eventbus-client"
+	"github.com/best-expendables/eventbus-client/codec"
+	"github.com/best-expendables/eventbus-client/helper"
+	"github.com/best-expendables/eventbus-client/producer_manager"
+	"github.com/best-expendables/eventbus-client/transport"
+	"github.com/streadway/amqp"
+)
+
+const testQueue = "package_creation"
+
+var (
+	oldKey = bytes.Repeat([]byte{1}, 32)
+	newKey = bytes.Repeat([]byte{2}, 32)
+)
+
+func newTestProvider(t *testing.T, current string) *StaticKeyProvider {
+	provider, err := NewStaticKeyProvider(current, map[string][]byte{"old": oldKey, "new": newKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return provider
+}
+
+func newTestMessage() *eventbusclient.Message {
+	return &eventbusclient.Message{
+		Id:         "1",
+		RoutingKey: testQueue,
+		Header: eventbusclient.Header{
+			Timestamp: time.Now(),
+			Publisher: "package",
+			EventName: "package_creation",
+		},
+		Payload: eventbusclient.Payload{EntityId: "entity", Data: map[string]interface{}{"email": "customer@example.com"}},
+	}
+}
+
+// publish the message encrypted by the provider and return its delivery
+func publish(t *testing.T, provider KeyProvider, msg *eventbusclient.Message) amqp.Delivery {
+	broker := transport.NewMemoryBroker()
+	p, err := producer_manager.NewProducerWithConfig(&eventbusclient.Config{
+		Transport: broker,
+		Topology:  &eventbusclient.Topology{Queues: []eventbusclient.Queue{{Name: testQueue}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	p.Use(PublishMiddleware(provider))
+	if err := p.Publish(context.Background(), msg); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, _ := broker.Dial("")
+	defer conn.Close()
+	ch, _ := conn.Channel()
+	deliveries, _ := ch.Consume(testQueue, "", true, false, false, false, nil)
+	select {
+	case d := <-deliveries:
+		return d
+	case <-time.After(time.Second):
+		t.Fatal("expect message in queue")
+	}
+	return amqp.Delivery{}
+}
+
+func consume(t *testing.T, provider KeyProvider, d amqp.Delivery) *eventbusclient.Message {
+	msg, err := helper.GetMessageFromDelivery(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ConsumeMiddleware(provider)(func(ctx context.Context, message *eventbusclient.Message) {})(context.Background(), msg)
+	return msg
+}
+
+func TestEncryption(t *testing.T) {
+	msg := newTestMessage()
+	d := publish(t, newTestProvider(t, "old"), msg)
+
+	if bytes.Contains(d.Body, []byte("customer@example.com")) {
+		t.Fatal("expect encrypted body")
+	}
+	if d.Headers[KeyIdHeader] != "old" {
+		t.Errorf("expect key id header, got %v", d.Headers[KeyIdHeader])
+	}
+	if msg.Payload.Data.(map[string]interface{})["email"] != "customer@example.com" {
+		t.Error("expect published message to keep its clear data")
+	}
+
+	// rotated, the old key still decrypts in-flight messages
+	consumed := consume(t, newTestProvider(t, "new"), d)
+	if consumed.Error != nil {
+		t.Fatal(consumed.Error)
+	}
+	data, ok := consumed.Payload.Data.(map[string]interface{})
+	if !ok || data["email"] != "customer@example.com" {
+		t.Errorf("expect decrypted data, got %v", consumed.Payload.Data)
+	}
+	if _, ok := consumed.Header.Extra[KeyIdHeader]; ok {
+		t.Error("expect encryption headers removed")
+	}
+}
+
+func TestEncryption_Codec(t *testing.T) {
+	eventbusclient.RegisterCodec(codec.Msgpack{})
+	msg := newTestMessage()
+	msg.ContentType = codec.ContentTypeMsgpack
+	provider := newTestProvider(t, "new")
+
+	consumed := consume(t, provider, publish(t, provider, msg))
+	if consumed.Error != nil {
+		t.Fatal(consumed.Error)
+	}
+	if consumed.ContentType != codec.ContentTypeMsgpack || consumed.Payload.Data.(map[string]interface{})["email"] != "customer@example.com" {
+		t.Errorf("expect msgpack data decrypted, got %+v", consumed)
+	}
+}
+
+func TestEncryption_Rejected(t *testing.T) {
+	provider := newTestProvider(t, "new")
+	d := publish(t, provider, newTestMessage())
+
+	tampered := d
+	tampered.MessageId = "2"
+	if msg := consume(t, provider, tampered); !errors.Is(msg.Error, ErrCiphertext) || msg.Status != eventbusclient.MessageStatusReject {
+		t.Errorf("expect message moved to another id to be rejected, got %v", msg.Error)
+	}
+
+	retired, _ := NewStaticKeyProvider("old", map[string][]byte{"old": oldKey})
+	msg := consume(t, retired, d)
+	var decryptErr *DecryptError
+	if !errors.As(msg.Error, &decryptErr) || decryptErr.KeyID != "new" {
+		t.Errorf("expect unknown key error, got %v", msg.Error)
+	}
+}
+
+func TestNewStaticKeyProvider(t *testing.T) {
+	if _, err := NewStaticKeyProvider("missing", map[string][]byte{"old": oldKey}); err == nil {
+		t.Error("expect error for missing current key")
+	}
+	if _, err := NewStaticKeyProvider("short", map[string][]byte{"short": []byte("short")}); err == nil {
+		t.Error("expect error for invalid key size")
+	}
+}
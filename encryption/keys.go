@@ -0,0 +1,55 @@
+package encryption
+
+import (
+	"context"
+	"fmt"
+)
+
+// StaticKeyProvider wrap data keys with AES-GCM under master keys held in memory, e.g. loaded from secrets
+type StaticKeyProvider struct {
+	currentKeyID string
+	keys         map[string][]byte
+}
+
+// NewStaticKeyProvider master keys by id, 16, 24 or 32 bytes long, new messages use the current one.
+// Rotate by adding a new key as current and removing the old one once its messages are consumed
+func NewStaticKeyProvider(currentKeyID string, keys map[string][]byte) (*StaticKeyProvider, error) {
+	if _, ok := keys[currentKeyID]; !ok {
+		return nil, fmt.Errorf("current master key %q is missing", currentKeyID)
+	}
+	for id, key := range keys {
+		if _, err := newGCM(key); err != nil {
+			return nil, fmt.Errorf("master key %q: %s", id, err)
+		}
+	}
+
+	return &StaticKeyProvider{currentKeyID: currentKeyID, keys: keys}, nil
+}
+
+func (p *StaticKeyProvider) CurrentKeyID() string {
+	return p.currentKeyID
+}
+
+func (p *StaticKeyProvider) WrapKey(ctx context.Context, keyID string, dataKey []byte) ([]byte, error) {
+	key, err := p.key(keyID)
+	if err != nil {
+		return nil, err
+	}
+	return seal(key, dataKey, []byte(keyID))
+}
+
+func (p *StaticKeyProvider) UnwrapKey(ctx context.Context, keyID string, wrapped []byte) ([]byte, error) {
+	key, err := p.key(keyID)
+	if err != nil {
+		return nil, err
+	}
+	return open(key, wrapped, []byte(keyID))
+}
+
+func (p *StaticKeyProvider) key(id string) ([]byte, error) {
+	key, ok := p.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown master key %q", id)
+	}
+	return key, nil
+}
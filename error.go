@@ -17,6 +17,11 @@ func (r retryError) Error() string {
 	return r.err.Error()
 }
 
+// Unwrap return the error to retry
+func (r retryError) Unwrap() error {
+	return r.err
+}
+
 func NewRetryError(err error) RetryErrorType {
 	return retryError{err: err}
 }
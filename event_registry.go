@@ -0,0 +1,123 @@
+package eventbusclient
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+
+	"gopkg.in/go-playground/validator.v9"
+)
+
+// EventVersionHeader extra header holding the version of the event payload
+const EventVersionHeader = "eventVersion"
+
+// DecodeError the payload data could not be decoded into, or validated as, the type registered for its event
+type DecodeError struct {
+	EventName string
+	Version   string
+	Type      string
+	Err       error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("decode event %q version %q into %s: %s", e.EventName, e.Version, e.Type, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+type eventKey struct {
+	name    string
+	version string
+}
+
+// EventRegistry map event names, and optionally versions, to the Go type of their payload data
+type EventRegistry struct {
+	mu       sync.RWMutex
+	types    map[eventKey]reflect.Type
+	validate *validator.Validate
+}
+
+func NewEventRegistry() *EventRegistry {
+	return &EventRegistry{
+		types:    map[eventKey]reflect.Type{},
+		validate: validator.New(),
+	}
+}
+
+// Register the payload data type of an event, prototype is a value or a pointer of the type
+func (r *EventRegistry) Register(eventName string, prototype interface{}) {
+	r.RegisterVersion(eventName, "", prototype)
+}
+
+// RegisterVersion the payload data type of an event version, given by the EventVersionHeader header
+func (r *EventRegistry) RegisterVersion(eventName, version string, prototype interface{}) {
+	t := reflect.TypeOf(prototype)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.types[eventKey{name: eventName, version: version}] = t
+}
+
+// Lookup type registered for the event version, falling back to the one registered without version
+func (r *EventRegistry) Lookup(eventName, version string) (reflect.Type, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if t, ok := r.types[eventKey{name: eventName, version: version}]; ok {
+		return t, true
+	}
+	t, ok := r.types[eventKey{name: eventName}]
+	return t, ok
+}
+
+// Decode the payload data into a pointer to the registered type and validate it, see Message.TypedData.
+// Payload.Data is left as received, so a retried or dead-lettered message keeps the fields the type does not declare.
+// Messages of unregistered events are left untouched
+func (r *EventRegistry) Decode(message *Message) error {
+	version, _ := message.Header.Extra[EventVersionHeader].(string)
+	t, ok := r.Lookup(message.Header.EventName, version)
+	if !ok {
+		return nil
+	}
+
+	decodeErr := func(err error) error {
+		return &DecodeError{EventName: message.Header.EventName, Version: version, Type: t.String(), Err: err}
+	}
+
+	codec, err := LookupCodec(message.ContentType)
+	if err != nil {
+		return decodeErr(err)
+	}
+
+	raw := []byte(message.Payload.rawData)
+	if encoded, ok := message.Payload.Data.(EncodedData); ok && len(raw) == 0 {
+		raw = encoded
+	}
+	if len(raw) == 0 {
+		// built in process rather than received, the data is encoded again
+		encoded, err := codec.Marshal(message.Payload.Data)
+		if err != nil {
+			return decodeErr(err)
+		}
+		raw = encoded
+	}
+
+	value := reflect.New(t)
+	if err := codec.Unmarshal(raw, value.Interface()); err != nil {
+		return decodeErr(err)
+	}
+	if t.Kind() == reflect.Struct {
+		if err := r.validate.Struct(value.Interface()); err != nil {
+			return decodeErr(err)
+		}
+	}
+
+	message.typedData = value.Interface()
+
+	return nil
+}
@@ -0,0 +1,86 @@
+package eventbusclient
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+type packageCreated struct {
+	PackageId string `json:"packageId" validate:"required"`
+	Weight    int64  `json:"weight"`
+}
+
+type packageCreatedV2 struct {
+	PackageId string `json:"packageId" validate:"required"`
+	Weight    int64  `json:"weight"`
+	Warehouse string `json:"warehouse" validate:"required"`
+}
+
+func receivedMessage(t *testing.T, eventName, body string) *Message {
+	message := &Message{Header: Header{EventName: eventName}}
+	if err := json.Unmarshal([]byte(body), &message.Payload); err != nil {
+		t.Fatal(err)
+	}
+	return message
+}
+
+func TestEventRegistry_Decode(t *testing.T) {
+	registry := NewEventRegistry()
+	registry.Register("package_creation", packageCreated{})
+	registry.RegisterVersion("package_creation", "2", &packageCreatedV2{})
+
+	message := receivedMessage(t, "package_creation", `{"entityId":"1","data":{"packageId":"P1","weight":9007199254740993,"carrier":"GHN"}}`)
+	if err := registry.Decode(message); err != nil {
+		t.Fatalf("decode failed: %s", err)
+	}
+	data, ok := message.TypedData().(*packageCreated)
+	if !ok {
+		t.Fatalf("expect payload data decoded into *packageCreated, got %T", message.TypedData())
+	}
+	// republished as received, with the fields the type does not declare
+	body, err := EncodePayload(ContentTypeJSON, message.Payload)
+	if err != nil || !strings.Contains(string(body), `"carrier":"GHN"`) {
+		t.Errorf("expect the payload data kept, got %s, %v", body, err)
+	}
+	// decoded from the raw data, not through float64
+	if data.PackageId != "P1" || data.Weight != 9007199254740993 {
+		t.Errorf("wrong data: %+v", data)
+	}
+
+	message = receivedMessage(t, "package_creation", `{"data":{"packageId":"P1","warehouse":"HCM"}}`)
+	message.Header.SetExtra(EventVersionHeader, "2")
+	if err := registry.Decode(message); err != nil {
+		t.Fatalf("decode failed: %s", err)
+	}
+	if _, ok := message.TypedData().(*packageCreatedV2); !ok {
+		t.Errorf("expect versioned type, got %T", message.TypedData())
+	}
+
+	message = receivedMessage(t, "package_update", `{"data":{"packageId":"P1"}}`)
+	if err := registry.Decode(message); err != nil || message.TypedData() != nil {
+		t.Errorf("expect unregistered event to be left untouched, got %v", err)
+	}
+	if _, ok := message.Payload.Data.(map[string]interface{}); !ok {
+		t.Errorf("expect map data for unregistered event, got %T", message.Payload.Data)
+	}
+}
+
+func TestEventRegistry_DecodeError(t *testing.T) {
+	registry := NewEventRegistry()
+	registry.Register("package_creation", packageCreated{})
+
+	for desc, body := range map[string]string{
+		"wrong type":       `{"data":{"packageId":1}}`,
+		"validation fails": `{"data":{"weight":1}}`,
+	} {
+		message := receivedMessage(t, "package_creation", body)
+		err := registry.Decode(message)
+
+		var decodeErr *DecodeError
+		if !errors.As(err, &decodeErr) || decodeErr.EventName != "package_creation" || decodeErr.Type != "eventbusclient.packageCreated" {
+			t.Errorf("%s: expect DecodeError, got %v", desc, err)
+		}
+	}
+}
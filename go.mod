@@ -3,19 +3,29 @@ module github.com/best-expendables/eventbus-client
 go 1.12
 
 require (
+	github.com/alicebob/miniredis/v2 v2.30.0
 	github.com/best-expendables/logger v0.0.0-20200511084842-8247cf6c59bd
 	github.com/best-expendables/newrelic-context v0.0.0-20200519103032-16e8dc9d4e57
 	github.com/best-expendables/trace v0.0.0-20200511055751-fb29d033fd2d
 	github.com/best-expendables/user-service-client v0.0.0-20200511060456-3fcf8ea240f5
 	github.com/go-playground/universal-translator v0.17.0 // indirect
-	github.com/go-redis/redis/v8 v8.0.0-beta.2
+	github.com/go-redis/redis/v8 v8.11.4
 	github.com/jinzhu/gorm v1.9.12
 	github.com/kelseyhightower/envconfig v1.4.0
+	github.com/klauspost/compress v1.13.6
 	github.com/leodido/go-urn v1.2.0 // indirect
 	github.com/newrelic/go-agent v3.5.0+incompatible
 	github.com/pkg/errors v0.9.1
+	github.com/prometheus/client_golang v1.7.1
 	github.com/sirupsen/logrus v1.6.0 // indirect
 	github.com/streadway/amqp v0.0.0-20200108173154-1c71cc93ed71
+	github.com/vmihailenco/msgpack/v5 v5.3.5
+	github.com/xeipuuv/gojsonschema v1.2.0
+	go.opentelemetry.io/otel v1.0.0
+	go.opentelemetry.io/otel/sdk v1.0.0
+	go.opentelemetry.io/otel/trace v1.0.0
+	golang.org/x/time v0.0.0-20210220033141-f8bda1e9f3ba
+	google.golang.org/protobuf v1.27.1
 	gopkg.in/go-playground/assert.v1 v1.2.1 // indirect
 	gopkg.in/go-playground/validator.v9 v9.31.0
 	gopkg.in/redis.v5 v5.2.9 // indirect
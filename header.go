@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -14,6 +15,33 @@ type Header struct {
 	TraceId     string    `json:"traceId"`
 	UserId      string    `json:"userId"`
 	XRetryCount int16     `json:"xRetryCount,omitempty"`
+	// Extra application headers, sent along the standard ones
+	Extra map[string]interface{} `json:"extra,omitempty"`
+}
+
+// standardHeaders keys mapped to Header fields or to the payload, any other key goes to Extra
+var standardHeaders = map[string]bool{
+	"timestamp": true, "Timestamp": true,
+	"publisher": true, "Publisher": true,
+	"eventName": true, "EventName": true,
+	"traceId": true, "TraceId": true,
+	"userId": true, "UserId": true,
+	"xRetryCount": true, EntityIdHeader: true,
+}
+
+// IsStandardHeader report whether the key is mapped to a Header field or to the payload
+func IsStandardHeader(key string) bool {
+	return standardHeaders[key]
+}
+
+// PublishTagHeader delivery tag of the publishing on its producer channel, it matches a return with its publishing
+const PublishTagHeader = "x-eventbus-publish-tag"
+
+// IsBrokerHeader report whether the key is set by the broker when dead-lettering, or is the PublishTagHeader.
+// They are not copied to Extra, so a republished message does not carry them again
+func IsBrokerHeader(key string) bool {
+	return key == "x-death" || key == PublishTagHeader ||
+		strings.HasPrefix(key, "x-first-death-") || strings.HasPrefix(key, "x-last-death-")
 }
 
 func (h *Header) FromMap(headers map[string]interface{}) error {
@@ -34,19 +62,46 @@ func (h *Header) FromMap(headers map[string]interface{}) error {
 	mapMessageHeader(headers, "traceId", &h.TraceId)
 	mapMessageHeader(headers, "userId", &h.UserId)
 
-	retryInt, ok := headers["xRetryCount"].(int16)
-	if ok {
-		h.XRetryCount = retryInt
-	} else {
-		h.XRetryCount = 0
+	h.XRetryCount = int16(HeaderInt64(headers["xRetryCount"]))
+
+	for key, value := range headers {
+		if IsStandardHeader(key) || IsBrokerHeader(key) {
+			continue
+		}
+		if h.Extra == nil {
+			h.Extra = map[string]interface{}{}
+		}
+		h.Extra[key] = value
 	}
 
 	return nil
 }
 
+// HeaderInt64 numeric header values come back as different int or float types depending on who encoded them
+func HeaderInt64(value interface{}) int64 {
+	switch v := value.(type) {
+	case int:
+		return int64(v)
+	case int8:
+		return int64(v)
+	case int16:
+		return int64(v)
+	case int32:
+		return int64(v)
+	case int64:
+		return v
+	case float32:
+		return int64(v)
+	case float64:
+		return int64(v)
+	default:
+		return 0
+	}
+}
+
 // ToMap return map of string data from header
 func (h *Header) ToMap() map[string]interface{} {
-	return map[string]interface{}{
+	headers := map[string]interface{}{
 		"timestamp":   h.Timestamp.Unix(),
 		"publisher":   h.Publisher,
 		"eventName":   h.EventName,
@@ -54,4 +109,43 @@ func (h *Header) ToMap() map[string]interface{} {
 		"userId":      h.UserId,
 		"xRetryCount": h.XRetryCount,
 	}
+	for key, value := range h.Extra {
+		if !IsStandardHeader(key) {
+			headers[key] = value
+		}
+	}
+
+	return headers
+}
+
+// SetExtra set an application header
+func (h *Header) SetExtra(key string, value interface{}) {
+	if h.Extra == nil {
+		h.Extra = map[string]interface{}{}
+	}
+	h.Extra[key] = value
+}
+
+// EncryptionKeyIdHeader extra header holding the id of the master key the payload is encrypted with, see package encryption
+const EncryptionKeyIdHeader = "x-encryption-key-id"
+
+// DeadlineHeader extra header holding the time the publisher stops caring about the message, see consumer_middleware.Timeout
+const DeadlineHeader = "x-deadline"
+
+// SetDeadline set the time after which consuming the message is pointless
+func (h *Header) SetDeadline(deadline time.Time) {
+	h.SetExtra(DeadlineHeader, deadline.UTC().Format(time.RFC3339Nano))
+}
+
+// Deadline set by the publisher, false when there is none or it is malformed
+func (h *Header) Deadline() (time.Time, bool) {
+	value, ok := h.Extra[DeadlineHeader].(string)
+	if !ok {
+		return time.Time{}, false
+	}
+	deadline, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return deadline, true
 }
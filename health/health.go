@@ -0,0 +1,204 @@
+package health
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/best-expendables/eventbus-client/consumer/connection_initializer"
+	"github.com/best-expendables/eventbus-client/consumer/facade"
+	"github.com/best-expendables/eventbus-client/producer_manager"
+)
+
+const (
+	StatusOK   = "ok"
+	StatusFail = "fail"
+)
+
+// Config components to check and the thresholds making them fail
+type Config struct {
+	// Consumer checked when set
+	Consumer facade.ConsumerFacade
+	// Producer checked when set
+	Producer producer_manager.Producer
+	// DisconnectedThreshold time the consumer or the producer may be disconnected, or a queue unsubscribed, before the pod is unready.
+	// Reconnecting usually takes a few seconds, unready at once when zero
+	DisconnectedThreshold time.Duration
+	// AckThreshold time messages of a queue may be in flight without any ack before the pod is unready, not checked when zero
+	AckThreshold time.Duration
+	// LivenessThreshold time disconnected, or in flight without any ack, before the pod is not live anymore and gets restarted.
+	// Liveness always succeeds when zero
+	LivenessThreshold time.Duration
+}
+
+// Report result of a check, served as JSON
+type Report struct {
+	Status   string          `json:"status"`
+	Errors   []string        `json:"errors,omitempty"`
+	Consumer *ConsumerReport `json:"consumer,omitempty"`
+	Producer *ProducerReport `json:"producer,omitempty"`
+}
+
+// ConsumerReport state of the consumer connection and queues
+type ConsumerReport struct {
+	Connection string                 `json:"connection"`
+	Queues     map[string]QueueReport `json:"queues"`
+}
+
+// QueueReport state of a consumed queue
+type QueueReport struct {
+	Subscribed   bool       `json:"subscribed"`
+	Paused       bool       `json:"paused"`
+	Workers      int        `json:"workers"`
+	InFlight     int        `json:"inFlight"`
+	LastAck      *time.Time `json:"lastAck,omitempty"`
+	WaitingSince *time.Time `json:"waitingSince,omitempty"`
+}
+
+// ProducerReport state of the producer connection
+type ProducerReport struct {
+	Connected bool `json:"connected"`
+}
+
+// Checker liveness and readiness of the event bus clients, for Kubernetes probes
+type Checker struct {
+	config Config
+	now    func() time.Time
+
+	mu sync.Mutex
+	// downSince first time a component was seen down, reset once it is up again
+	downSince map[string]time.Time
+}
+
+// NewChecker create a checker of the configured components
+func NewChecker(config Config) *Checker {
+	return &Checker{
+		config:    config,
+		now:       time.Now,
+		downSince: map[string]time.Time{},
+	}
+}
+
+// Readiness fails when a component is down longer than config.DisconnectedThreshold, or a queue has no ack for longer than config.AckThreshold
+func (c *Checker) Readiness() Report {
+	return c.check(c.config.DisconnectedThreshold, c.config.AckThreshold)
+}
+
+// Liveness fails when a component is down, or a queue has no ack, for longer than config.LivenessThreshold
+func (c *Checker) Liveness() Report {
+	if c.config.LivenessThreshold <= 0 {
+		return c.check(-1, 0)
+	}
+	return c.check(c.config.LivenessThreshold, c.config.LivenessThreshold)
+}
+
+// ReadinessHandler serve the readiness report, 503 when it fails
+func (c *Checker) ReadinessHandler() http.Handler {
+	return reportHandler(c.Readiness)
+}
+
+// LivenessHandler serve the liveness report, 503 when it fails
+func (c *Checker) LivenessHandler() http.Handler {
+	return reportHandler(c.Liveness)
+}
+
+// Handler serve the liveness report on /live and the readiness report on /ready
+func (c *Checker) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/live", c.LivenessHandler())
+	mux.Handle("/ready", c.ReadinessHandler())
+	return mux
+}
+
+func reportHandler(check func() Report) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		report := check()
+		w.Header().Set("Content-Type", "application/json")
+		if report.Status != StatusOK {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		_ = json.NewEncoder(w).Encode(report)
+	})
+}
+
+// check the components, down ones fail past the disconnected threshold, unless negative. Queues waiting for an ack fail past the ack one, unless zero
+func (c *Checker) check(disconnected, ack time.Duration) Report {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	report := Report{Status: StatusOK}
+	fail := func(format string, args ...interface{}) {
+		report.Status = StatusFail
+		report.Errors = append(report.Errors, fmt.Sprintf(format, args...))
+	}
+	down := func(key string, isDown bool) (time.Duration, bool) {
+		if !isDown {
+			delete(c.downSince, key)
+			return 0, false
+		}
+		since, ok := c.downSince[key]
+		if !ok {
+			since = now
+			c.downSince[key] = now
+		}
+		elapsed := now.Sub(since)
+		return elapsed, disconnected >= 0 && elapsed >= disconnected
+	}
+
+	if c.config.Consumer != nil {
+		status := c.config.Consumer.Status()
+		report.Consumer = &ConsumerReport{Connection: status.Connection, Queues: map[string]QueueReport{}}
+		connected := status.Connection == connection_initializer.ConnectionManagerStatusConnected
+		if elapsed, failed := down("consumer", !connected); failed {
+			fail("consumer connection %s for %s", status.Connection, elapsed)
+		}
+
+		queueNames := make([]string, 0, len(status.Queues))
+		for queueName := range status.Queues {
+			queueNames = append(queueNames, queueName)
+		}
+		sort.Strings(queueNames)
+		for _, queueName := range queueNames {
+			queue := status.Queues[queueName]
+			report.Consumer.Queues[queueName] = newQueueReport(queue)
+			// a lost connection is reported once
+			if elapsed, failed := down("queue:"+queueName, connected && !queue.Subscribed && !queue.Paused); failed {
+				fail("queue %s not subscribed for %s", queueName, elapsed)
+			}
+			if waiting := now.Sub(queue.WaitingSince); ack > 0 && !queue.WaitingSince.IsZero() && waiting >= ack {
+				fail("queue %s has messages in flight without ack for %s", queueName, waiting)
+			}
+		}
+	}
+
+	if c.config.Producer != nil {
+		connected := c.config.Producer.Connected()
+		report.Producer = &ProducerReport{Connected: connected}
+		if elapsed, failed := down("producer", !connected); failed {
+			fail("producer disconnected for %s", elapsed)
+		}
+	}
+	return report
+}
+
+func newQueueReport(queue facade.QueueStatus) QueueReport {
+	report := QueueReport{
+		Subscribed: queue.Subscribed,
+		Paused:     queue.Paused,
+		Workers:    queue.Workers,
+		InFlight:   queue.InFlight,
+	}
+	if !queue.LastAck.IsZero() {
+		lastAck := queue.LastAck
+		report.LastAck = &lastAck
+	}
+	if !queue.WaitingSince.IsZero() {
+		waitingSince := queue.WaitingSince
+		report.WaitingSince = &waitingSince
+	}
+	return report
+}
@@ -0,0 +1,140 @@
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/best-expendables/eventbus-client/consumer/connection_initializer"
+	"github.com/best-expendables/eventbus-client/consumer/consumer_manager"
+	"github.com/best-expendables/eventbus-client/consumer/facade"
+	"github.com/best-expendables/eventbus-client/producer_manager"
+)
+
+type fakeConsumer struct {
+	facade.ConsumerFacade
+	status facade.Status
+}
+
+func (c *fakeConsumer) Status() facade.Status {
+	return c.status
+}
+
+func newTestChecker(config Config) (*Checker, *fakeConsumer, *bool, *time.Time) {
+	consumer := &fakeConsumer{status: facade.Status{
+		Connection: connection_initializer.ConnectionManagerStatusConnected,
+		Queues: map[string]facade.QueueStatus{
+			"package_creation": {QueueStatus: consumer_manager.QueueStatus{Workers: 2}, Subscribed: true},
+		},
+	}}
+	connected := true
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	config.Consumer = consumer
+	config.Producer = producer_manager.ProducerMock{ConnectedFn: func() bool { return connected }}
+	checker := NewChecker(config)
+	checker.now = func() time.Time { return now }
+	return checker, consumer, &connected, &now
+}
+
+func TestChecker_Readiness(t *testing.T) {
+	checker, consumer, connected, now := newTestChecker(Config{DisconnectedThreshold: 10 * time.Second, AckThreshold: time.Minute})
+
+	if report := checker.Readiness(); report.Status != StatusOK || report.Consumer.Queues["package_creation"].Workers != 2 {
+		t.Fatalf("expect ready, got: %+v", report)
+	}
+
+	// a short disconnection is tolerated
+	consumer.status.Connection = connection_initializer.ConnectionManagerStatusDisconnected
+	*connected = false
+	if report := checker.Readiness(); report.Status != StatusOK {
+		t.Errorf("expect ready while reconnecting, got: %+v", report)
+	}
+	*now = now.Add(10 * time.Second)
+	if report := checker.Readiness(); report.Status != StatusFail || len(report.Errors) != 2 {
+		t.Errorf("expect consumer and producer disconnected, got: %+v", report)
+	}
+
+	consumer.status.Connection = connection_initializer.ConnectionManagerStatusConnected
+	*connected = true
+	if report := checker.Readiness(); report.Status != StatusOK {
+		t.Errorf("expect ready once reconnected, got: %+v", report)
+	}
+
+	queue := consumer.status.Queues["package_creation"]
+	queue.InFlight = 1
+	queue.WaitingSince = now.Add(-time.Minute)
+	consumer.status.Queues["package_creation"] = queue
+	if report := checker.Readiness(); report.Status != StatusFail || len(report.Errors) != 1 {
+		t.Errorf("expect queue without ack to fail, got: %+v", report)
+	}
+}
+
+func TestChecker_PausedQueueIsReady(t *testing.T) {
+	checker, consumer, _, _ := newTestChecker(Config{})
+	consumer.status.Queues["package_creation"] = facade.QueueStatus{Paused: true}
+	if report := checker.Readiness(); report.Status != StatusOK {
+		t.Errorf("expect paused queue to be ready, got: %+v", report)
+	}
+
+	consumer.status.Queues["package_creation"] = facade.QueueStatus{}
+	if report := checker.Readiness(); report.Status != StatusFail {
+		t.Errorf("expect unsubscribed queue to fail, got: %+v", report)
+	}
+}
+
+func TestChecker_Liveness(t *testing.T) {
+	checker, consumer, _, now := newTestChecker(Config{})
+	consumer.status.Connection = connection_initializer.ConnectionManagerStatusDisconnected
+	*now = now.Add(time.Hour)
+	if report := checker.Liveness(); report.Status != StatusOK {
+		t.Errorf("expect liveness not checked without threshold, got: %+v", report)
+	}
+
+	checker, consumer, _, now = newTestChecker(Config{LivenessThreshold: time.Minute})
+	consumer.status.Connection = connection_initializer.ConnectionManagerStatusDisconnected
+	if report := checker.Liveness(); report.Status != StatusOK {
+		t.Errorf("expect live right after disconnection, got: %+v", report)
+	}
+	*now = now.Add(time.Minute)
+	if report := checker.Liveness(); report.Status != StatusFail {
+		t.Errorf("expect not live after the threshold, got: %+v", report)
+	}
+}
+
+func TestChecker_Handler(t *testing.T) {
+	checker, _, connected, _ := newTestChecker(Config{})
+	lastAck := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	checker.config.Consumer.(*fakeConsumer).status.Queues["package_creation"] = facade.QueueStatus{
+		QueueStatus: consumer_manager.QueueStatus{Workers: 1, LastAck: lastAck},
+		Subscribed:  true,
+	}
+
+	serve := func(path string) (*httptest.ResponseRecorder, Report) {
+		recorder := httptest.NewRecorder()
+		checker.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+		var report Report
+		if err := json.Unmarshal(recorder.Body.Bytes(), &report); err != nil {
+			t.Fatalf("invalid report %s: %s", recorder.Body.String(), err)
+		}
+		return recorder, report
+	}
+
+	recorder, report := serve("/ready")
+	if recorder.Code != http.StatusOK || recorder.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("wrong response: %d %s", recorder.Code, recorder.Header().Get("Content-Type"))
+	}
+	if queue := report.Consumer.Queues["package_creation"]; queue.LastAck == nil || !queue.LastAck.Equal(lastAck) || !report.Producer.Connected {
+		t.Errorf("wrong report: %s", recorder.Body.String())
+	}
+
+	*connected = false
+	if recorder, _ := serve("/ready"); recorder.Code != http.StatusServiceUnavailable {
+		t.Errorf("expect 503 when unready, got %d", recorder.Code)
+	}
+	if recorder, _ := serve("/live"); recorder.Code != http.StatusOK {
+		t.Errorf("expect 200 when live, got %d", recorder.Code)
+	}
+}
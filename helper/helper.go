@@ -91,26 +91,34 @@ func GetLogFieldFromMessage(message *eventbusclient.Message) logger.Fields {
 	return fields
 }
 
-//From rabbitmq delivery data, build the original message
-func GetMessageFromDelivery(d amqp.Delivery) *eventbusclient.Message {
-	payload := eventbusclient.Payload{}
-	msg := &eventbusclient.Message{}
-	err := json.Unmarshal(d.Body, &payload)
+//From rabbitmq delivery data, build the original message.
+//The error is returned instead of being set on Message.Error, the message is nil when the body can not be decoded
+func GetMessageFromDelivery(d amqp.Delivery) (*eventbusclient.Message, error) {
+	d, err := DecompressDelivery(d)
 	if err != nil {
-		msg.Error = err
-		return msg
+		return nil, err
 	}
 
-	t, _ := d.Headers["Timestamp"].(int64)
+	contentType := DeliveryContentType(d)
+	payload, err := eventbusclient.DecodePayload(contentType, d.Body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != eventbusclient.ContentTypeJSON {
+		payload.EntityId = getString(d.Headers[eventbusclient.EntityIdHeader])
+	}
+
+	t := eventbusclient.HeaderInt64(d.Headers["Timestamp"])
 	if t == 0 {
-		t, _ = d.Headers["timestamp"].(int64)
+		t = eventbusclient.HeaderInt64(d.Headers["timestamp"])
 	}
 	timestamp := time.Unix(t, 0)
-	retry, _ := d.Headers["xRetryCount"].(int16)
-	msg = &eventbusclient.Message{
-		Id:         d.MessageId,
-		Exchange:   d.Exchange,
-		RoutingKey: d.RoutingKey,
+	retry := int16(eventbusclient.HeaderInt64(d.Headers["xRetryCount"]))
+	msg := &eventbusclient.Message{
+		Id:          d.MessageId,
+		Exchange:    d.Exchange,
+		RoutingKey:  d.RoutingKey,
+		ContentType: contentType,
 		Header: eventbusclient.Header{
 			Timestamp:   timestamp,
 			Publisher:   getHeader(d.Headers, "Publisher"),
@@ -122,8 +130,36 @@ func GetMessageFromDelivery(d amqp.Delivery) *eventbusclient.Message {
 		Payload: payload,
 		Status:  eventbusclient.MessageStatusAck,
 	}
+	for key, value := range d.Headers {
+		if !eventbusclient.IsStandardHeader(key) && !eventbusclient.IsBrokerHeader(key) {
+			msg.Header.SetExtra(key, value)
+		}
+	}
+
+	return msg, nil
+}
 
-	return msg
+// DecompressDelivery delivery with its body decompressed according to its content encoding
+func DecompressDelivery(d amqp.Delivery) (amqp.Delivery, error) {
+	body, err := eventbusclient.Decompress(d.ContentEncoding, d.Body)
+	if err != nil {
+		return d, err
+	}
+	d.Body = body
+	d.ContentEncoding = ""
+	return d, nil
+}
+
+// DeliveryContentType codec content type of the delivery.
+// Deliveries without content type, or with one no codec is registered for, are JSON when their body is valid JSON
+func DeliveryContentType(d amqp.Delivery) string {
+	if d.ContentType == "" {
+		return eventbusclient.ContentTypeJSON
+	}
+	if _, err := eventbusclient.LookupCodec(d.ContentType); err != nil && json.Valid(d.Body) {
+		return eventbusclient.ContentTypeJSON
+	}
+	return d.ContentType
 }
 
 //Build the context from Message, the context now will have data for logging and tracing
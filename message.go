@@ -1,6 +1,7 @@
 package eventbusclient
 
 import (
+	"encoding/json"
 	"fmt"
 )
 
@@ -16,19 +17,57 @@ type (
 		Id         string
 		Exchange   string
 		RoutingKey string
-		Header     Header
-		Payload    Payload `validate:"required,dive"`
-		Status     string
-		Error      error
+		Queue      string // Queue the message was consumed from, empty when publishing
+		// ContentType codec of the payload, JSON when empty, see RegisterCodec
+		ContentType string
+		Header      Header
+		Payload     Payload `validate:"required,dive"`
+		Status      string
+		Error       error
+
+		typedData interface{}
 	}
 
 	// Payload message's data
 	Payload struct {
 		EntityId string      `json:"entityId"`
 		Data     interface{} `json:"data" validate:"required"`
+
+		// rawData data as received, decoded again into the registered type by EventRegistry
+		rawData json.RawMessage
 	}
 )
 
+// TypedData pointer to the payload data decoded by EventRegistry, nil when the event type is not registered
+func (m *Message) TypedData() interface{} {
+	return m.typedData
+}
+
+// RawData data as received, nil for messages built in process
+func (p Payload) RawData() []byte {
+	return p.rawData
+}
+
+// UnmarshalJSON keep the raw data so it can be decoded into the type registered for the event
+func (p *Payload) UnmarshalJSON(b []byte) error {
+	var wire struct {
+		EntityId string          `json:"entityId"`
+		Data     json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(b, &wire); err != nil {
+		return err
+	}
+
+	p.EntityId = wire.EntityId
+	p.rawData = wire.Data
+	p.Data = nil
+	if len(wire.Data) == 0 {
+		return nil
+	}
+
+	return json.Unmarshal(wire.Data, &p.Data)
+}
+
 func mapMessageHeader(headers map[string]interface{}, key string, header *string) {
 	value, exists := headers[key]
 	if !exists {
@@ -36,3 +75,40 @@ func mapMessageHeader(headers map[string]interface{}, key string, header *string
 	}
 	*header = fmt.Sprintf("%v", value)
 }
+
+// Clone copy of the message a handler can change without touching the original. Payload data and extra headers
+// are copied deeply as far as they hold maps, slices and bytes, as decoded from the wire; typed data is shared
+func (m *Message) Clone() *Message {
+	clone := *m
+	clone.Payload.Data = deepCopy(m.Payload.Data)
+	if m.Payload.rawData != nil {
+		clone.Payload.rawData = append(json.RawMessage(nil), m.Payload.rawData...)
+	}
+	if m.Header.Extra != nil {
+		clone.Header.Extra = deepCopy(m.Header.Extra).(map[string]interface{})
+	}
+	return &clone
+}
+
+func deepCopy(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			copied[key] = deepCopy(item)
+		}
+		return copied
+	case []interface{}:
+		copied := make([]interface{}, len(v))
+		for i, item := range v {
+			copied[i] = deepCopy(item)
+		}
+		return copied
+	case EncodedData:
+		return append(EncodedData(nil), v...)
+	case []byte:
+		return append([]byte(nil), v...)
+	default:
+		return value
+	}
+}
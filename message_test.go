@@ -171,3 +171,39 @@ func TestHeader_ToMap(t *testing.T) {
 		}
 	}
 }
+
+func TestHeader_Extra(t *testing.T) {
+	header := Header{Timestamp: time.Now(), Publisher: "package"}
+	header.SetExtra("x-tenant", "vn")
+	header.SetExtra("publisher", "overridden")
+
+	headerMap := header.ToMap()
+	if headerMap["x-tenant"] != "vn" || headerMap["publisher"] != "package" {
+		t.Errorf("wrong header map: %v", headerMap)
+	}
+
+	decoded := &Header{}
+	if err := decoded.FromMap(headerMap); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Extra["x-tenant"] != "vn" || len(decoded.Extra) != 1 {
+		t.Errorf("expect extra header to be decoded, got: %v", decoded.Extra)
+	}
+}
+
+func TestMessage_Clone(t *testing.T) {
+	message := &Message{
+		Header:  Header{Extra: map[string]interface{}{"x-tags": []interface{}{"a"}}},
+		Payload: Payload{Data: map[string]interface{}{"items": []interface{}{map[string]interface{}{"id": "1"}}}},
+	}
+	clone := message.Clone()
+	clone.Header.Extra["x-tags"].([]interface{})[0] = "b"
+	clone.Payload.Data.(map[string]interface{})["items"].([]interface{})[0].(map[string]interface{})["id"] = "2"
+
+	if message.Header.Extra["x-tags"].([]interface{})[0] != "a" {
+		t.Error("expect the clone extra headers copied")
+	}
+	if message.Payload.Data.(map[string]interface{})["items"].([]interface{})[0].(map[string]interface{})["id"] != "1" {
+		t.Error("expect the clone payload data copied")
+	}
+}
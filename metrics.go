@@ -0,0 +1,46 @@
+package eventbusclient
+
+import "time"
+
+// Outcomes of a consumed message reported to MetricsSink.MessageOutcome
+const (
+	OutcomeAck      = MessageStatusAck
+	OutcomeNack     = MessageStatusNack
+	OutcomeReject   = MessageStatusReject
+	OutcomeRetry    = "retry"
+	OutcomePanic    = "panic"
+	OutcomeConsumed = "consumed"
+)
+
+// Components reported to MetricsSink.Reconnected
+const (
+	ComponentConsumer = "consumer"
+	ComponentProducer = "producer"
+)
+
+// MetricsSink receive the client metrics, the metrics package implements it with Prometheus
+type MetricsSink interface {
+	// MessageOutcome count a consumed message by outcome, a message counts as consumed plus its final outcomes
+	MessageOutcome(queue, event, outcome string)
+	// HandlerDuration time spent consuming a message
+	HandlerDuration(queue, event string, duration time.Duration)
+	// MessagePublished count a publishing and its latency until confirmed, retries included
+	MessagePublished(exchange, routingKey, event string, err error, duration time.Duration)
+	// PublishRetried count a publishing attempt retried by the producer
+	PublishRetried(exchange, routingKey string)
+	// Reconnected count a connection regained after a loss
+	Reconnected(component string)
+}
+
+// NopMetrics discard every metric
+type NopMetrics struct{}
+
+func (NopMetrics) MessageOutcome(string, string, string) {}
+
+func (NopMetrics) HandlerDuration(string, string, time.Duration) {}
+
+func (NopMetrics) MessagePublished(string, string, string, error, time.Duration) {}
+
+func (NopMetrics) PublishRetried(string, string) {}
+
+func (NopMetrics) Reconnected(string) {}
@@ -0,0 +1,113 @@
+package metrics
+
+import (
+	"time"
+
+	eventbusclient "github.com/best-expendables/eventbus-client"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	namespace     = "eventbus"
+	resultSuccess = "success"
+	resultError   = "error"
+)
+
+// PrometheusSink eventbusclient.MetricsSink exposing the client metrics as Prometheus collectors
+type PrometheusSink struct {
+	consumerMessages *prometheus.CounterVec
+	handlerDuration  *prometheus.HistogramVec
+	published        *prometheus.CounterVec
+	publishDuration  *prometheus.HistogramVec
+	publishRetries   *prometheus.CounterVec
+	reconnects       *prometheus.CounterVec
+}
+
+// NewPrometheusSink create the collectors and register them, use prometheus.DefaultRegisterer to expose them with promhttp.Handler
+func NewPrometheusSink(registerer prometheus.Registerer) (*PrometheusSink, error) {
+	sink := &PrometheusSink{
+		consumerMessages: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "consumer",
+			Name:      "messages_total",
+			Help:      "Consumed messages by outcome: consumed, ack, nack, reject, retry and panic.",
+		}, []string{"queue", "event", "outcome"}),
+		handlerDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "consumer",
+			Name:      "handler_duration_seconds",
+			Help:      "Time spent consuming a message.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"queue", "event"}),
+		published: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "producer",
+			Name:      "published_total",
+			Help:      "Published messages by result.",
+		}, []string{"exchange", "routing_key", "event", "result"}),
+		publishDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "producer",
+			Name:      "publish_duration_seconds",
+			Help:      "Time until a publishing is confirmed, retries included.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"exchange", "routing_key", "event"}),
+		publishRetries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "producer",
+			Name:      "retries_total",
+			Help:      "Publishing attempts retried.",
+		}, []string{"exchange", "routing_key"}),
+		reconnects: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "reconnects_total",
+			Help:      "Connections regained after a loss.",
+		}, []string{"component"}),
+	}
+
+	for _, collector := range sink.collectors() {
+		if err := registerer.Register(collector); err != nil {
+			return nil, err
+		}
+	}
+
+	return sink, nil
+}
+
+func (s *PrometheusSink) collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		s.consumerMessages,
+		s.handlerDuration,
+		s.published,
+		s.publishDuration,
+		s.publishRetries,
+		s.reconnects,
+	}
+}
+
+func (s *PrometheusSink) MessageOutcome(queue, event, outcome string) {
+	s.consumerMessages.WithLabelValues(queue, event, outcome).Inc()
+}
+
+func (s *PrometheusSink) HandlerDuration(queue, event string, duration time.Duration) {
+	s.handlerDuration.WithLabelValues(queue, event).Observe(duration.Seconds())
+}
+
+func (s *PrometheusSink) MessagePublished(exchange, routingKey, event string, err error, duration time.Duration) {
+	result := resultSuccess
+	if err != nil {
+		result = resultError
+	}
+	s.published.WithLabelValues(exchange, routingKey, event, result).Inc()
+	s.publishDuration.WithLabelValues(exchange, routingKey, event).Observe(duration.Seconds())
+}
+
+func (s *PrometheusSink) PublishRetried(exchange, routingKey string) {
+	s.publishRetries.WithLabelValues(exchange, routingKey).Inc()
+}
+
+func (s *PrometheusSink) Reconnected(component string) {
+	s.reconnects.WithLabelValues(component).Inc()
+}
+
+var _ eventbusclient.MetricsSink = (*PrometheusSink)(nil)
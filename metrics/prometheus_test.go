@@ -0,0 +1,112 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	eventbusclient "github.com/best-expendables/eventbus-client"
+	"github.com/best-expendables/eventbus-client/consumer/consumer_middleware"
+	"github.com/best-expendables/eventbus-client/producer_manager"
+	"github.com/best-expendables/eventbus-client/transport"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+const testQueue = "package_creation"
+
+func newTestMessage() *eventbusclient.Message {
+	return &eventbusclient.Message{
+		Id:         "1",
+		RoutingKey: testQueue,
+		Queue:      testQueue,
+		Header:     eventbusclient.Header{Timestamp: time.Now(), Publisher: "package", EventName: "package_creation"},
+		Payload:    eventbusclient.Payload{EntityId: "entity", Data: map[string]interface{}{"id": "1"}},
+		Status:     eventbusclient.MessageStatusAck,
+	}
+}
+
+func TestPrometheusSink_Consumer(t *testing.T) {
+	sink, err := NewPrometheusSink(prometheus.NewRegistry())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	consume := func(handler consumer_middleware.ConsumeFunc) {
+		h := consumer_middleware.Metrics(sink)(consumer_middleware.Recover(handler))
+		h(context.Background(), newTestMessage())
+	}
+	consume(func(ctx context.Context, message *eventbusclient.Message) {})
+	consume(func(ctx context.Context, message *eventbusclient.Message) {
+		message.Error = eventbusclient.NewRetryError(errors.New("timeout"))
+	})
+	consume(func(ctx context.Context, message *eventbusclient.Message) {
+		message.Status = eventbusclient.MessageStatusReject
+	})
+	consume(func(ctx context.Context, message *eventbusclient.Message) {
+		panic("boom")
+	})
+
+	expected := map[string]float64{
+		eventbusclient.OutcomeConsumed: 4,
+		eventbusclient.OutcomeAck:      3,
+		eventbusclient.OutcomeRetry:    1,
+		eventbusclient.OutcomeReject:   1,
+		eventbusclient.OutcomePanic:    1,
+	}
+	for outcome, count := range expected {
+		if got := testutil.ToFloat64(sink.consumerMessages.WithLabelValues(testQueue, "package_creation", outcome)); got != count {
+			t.Errorf("outcome %s: expect %v, got %v", outcome, count, got)
+		}
+	}
+	if count := testutil.CollectAndCount(sink.handlerDuration); count != 1 {
+		t.Errorf("expect handler duration to be observed, got %d series", count)
+	}
+}
+
+func TestPrometheusSink_Producer(t *testing.T) {
+	sink, err := NewPrometheusSink(prometheus.NewRegistry())
+	if err != nil {
+		t.Fatal(err)
+	}
+	broker := transport.NewMemoryBroker()
+	p, err := producer_manager.NewProducerWithConfig(&eventbusclient.Config{
+		Transport:            broker,
+		Metrics:              sink,
+		PublishMaxAttempts:   2,
+		PublishRetryInterval: time.Millisecond,
+		Topology:             &eventbusclient.Topology{Queues: []eventbusclient.Queue{{Name: testQueue}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	p.Use(producer_manager.PublishMetrics(sink))
+
+	if err := p.Publish(context.Background(), newTestMessage()); err != nil {
+		t.Fatal(err)
+	}
+	missing := newTestMessage()
+	missing.Exchange = "missing_exchange"
+	_ = p.Publish(context.Background(), missing)
+
+	if got := testutil.ToFloat64(sink.published.WithLabelValues("", testQueue, "package_creation", resultSuccess)); got != 1 {
+		t.Errorf("expect 1 successful publishing, got %v", got)
+	}
+	if got := testutil.ToFloat64(sink.published.WithLabelValues("missing_exchange", testQueue, "package_creation", resultError)); got != 1 {
+		t.Errorf("expect 1 failed publishing, got %v", got)
+	}
+	if got := testutil.ToFloat64(sink.publishRetries.WithLabelValues("missing_exchange", testQueue)); got != 1 {
+		t.Errorf("expect 1 retry, got %v", got)
+	}
+
+	broker.DropConnections()
+	deadline := time.Now().Add(5 * time.Second)
+	for testutil.ToFloat64(sink.reconnects.WithLabelValues(eventbusclient.ComponentProducer)) != 1 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := testutil.ToFloat64(sink.reconnects.WithLabelValues(eventbusclient.ComponentProducer)); got != 1 {
+		t.Errorf("expect 1 producer reconnect, got %v", got)
+	}
+}
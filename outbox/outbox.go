@@ -0,0 +1,119 @@
+package outbox
+
+import (
+	"encoding/json"
+	"time"
+
+	eventbusclient "github.com/best-expendables/eventbus-client"
+	"github.com/jinzhu/gorm"
+	"gopkg.in/go-playground/validator.v9"
+)
+
+// TableName outbox table, change it before calling Migrate
+var TableName = "eventbus_outbox"
+
+var validate = validator.New()
+
+// Record message waiting in the outbox, SentAt is set once the broker confirmed it
+type Record struct {
+	ID          uint64     `gorm:"primary_key"`
+	MessageID   string     `gorm:"type:varchar(255);not null"`
+	Exchange    string     `gorm:"type:varchar(255);not null"`
+	RoutingKey  string     `gorm:"type:varchar(255);not null"`
+	ContentType string     `gorm:"type:varchar(255)"`
+	Header      string     `gorm:"type:text;not null"`
+	Payload     string     `gorm:"type:text;not null"`
+	Attempts    int        `gorm:"not null;default:0"`
+	LastError   string     `gorm:"type:text"`
+	LockedBy    string     `gorm:"type:varchar(255);index"`
+	LockedUntil *time.Time `gorm:"index"`
+	SentAt      *time.Time `gorm:"index"`
+	CreatedAt   time.Time
+}
+
+func (Record) TableName() string {
+	return TableName
+}
+
+// Migrate create or update the outbox table
+func Migrate(db *gorm.DB) error {
+	return db.AutoMigrate(&Record{}).Error
+}
+
+// Store write the message into the outbox, within the caller's transaction.
+// It is published by the relay once the transaction is committed, and dropped with it on rollback
+func Store(tx *gorm.DB, msg *eventbusclient.Message) error {
+	if err := validate.Struct(*msg); err != nil {
+		return err
+	}
+
+	record, err := newRecord(msg)
+	if err != nil {
+		return err
+	}
+
+	return tx.Create(record).Error
+}
+
+func newRecord(msg *eventbusclient.Message) (*Record, error) {
+	header, err := json.Marshal(msg.Header)
+	if err != nil {
+		return nil, err
+	}
+	stored := msg.Payload
+	if !isJSON(msg.ContentType) {
+		// kept encoded with the message codec, Message gives it back as EncodedData
+		data, err := eventbusclient.EncodePayload(msg.ContentType, msg.Payload)
+		if err != nil {
+			return nil, err
+		}
+		stored = eventbusclient.Payload{EntityId: msg.Payload.EntityId, Data: []byte(data)}
+	}
+	payload, err := json.Marshal(stored)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Record{
+		MessageID:   msg.Id,
+		Exchange:    msg.Exchange,
+		RoutingKey:  msg.RoutingKey,
+		ContentType: msg.ContentType,
+		Header:      string(header),
+		Payload:     string(payload),
+	}, nil
+}
+
+func isJSON(contentType string) bool {
+	return contentType == "" || contentType == eventbusclient.ContentTypeJSON
+}
+
+// Message rebuild the stored message
+func (r *Record) Message() (*eventbusclient.Message, error) {
+	msg := &eventbusclient.Message{
+		Id:          r.MessageID,
+		Exchange:    r.Exchange,
+		RoutingKey:  r.RoutingKey,
+		ContentType: r.ContentType,
+	}
+	if err := json.Unmarshal([]byte(r.Header), &msg.Header); err != nil {
+		return nil, err
+	}
+	if isJSON(r.ContentType) {
+		if err := json.Unmarshal([]byte(r.Payload), &msg.Payload); err != nil {
+			return nil, err
+		}
+		return msg, nil
+	}
+
+	var encoded struct {
+		EntityId string `json:"entityId"`
+		Data     []byte `json:"data"`
+	}
+	if err := json.Unmarshal([]byte(r.Payload), &encoded); err != nil {
+		return nil, err
+	}
+	msg.Payload = eventbusclient.Payload{EntityId: encoded.EntityId, Data: eventbusclient.EncodedData(encoded.Data)}
+
+	return msg, nil
+}
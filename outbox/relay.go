@@ -0,0 +1,187 @@
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+
+	eventbusclient "github.com/best-expendables/eventbus-client"
+	"github.com/best-expendables/eventbus-client/producer_manager"
+	"github.com/best-expendables/logger"
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	defaultBatchSize    = 100
+	defaultPollInterval = time.Second
+	defaultLockTimeout  = time.Minute
+)
+
+// RelayConfig zero values fall back to the defaults
+type RelayConfig struct {
+	// ID identify the relay in the locked_by column, hostname and pid by default
+	ID string
+	// BatchSize number of records claimed and published at once
+	BatchSize int
+	// PollInterval wait between two polls when the outbox is drained
+	PollInterval time.Duration
+	// LockTimeout how long claimed records stay locked, another relay takes them over after it.
+	// It must be longer than publishing a batch, a record is published twice otherwise
+	LockTimeout time.Duration
+	// MaxAttempts records failing this many times are left in the outbox for inspection, 0 retries forever
+	MaxAttempts int
+}
+
+// Relay publish the outbox records through the producer and mark them sent once confirmed.
+// Several relays can run on the same table, records are claimed with a lock lease.
+// Delivery is at least once: a relay dying between the confirm and the update publishes the record again
+type Relay struct {
+	db       *gorm.DB
+	producer producer_manager.Producer
+	config   RelayConfig
+	sequence uint64
+}
+
+func NewRelay(db *gorm.DB, producer producer_manager.Producer, config RelayConfig) *Relay {
+	if config.ID == "" {
+		hostname, _ := os.Hostname()
+		config.ID = fmt.Sprintf("%s-%d", hostname, os.Getpid())
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = defaultBatchSize
+	}
+	if config.PollInterval <= 0 {
+		config.PollInterval = defaultPollInterval
+	}
+	if config.LockTimeout <= 0 {
+		config.LockTimeout = defaultLockTimeout
+	}
+
+	return &Relay{db: db, producer: producer, config: config}
+}
+
+// Run relay until the context is done
+func (r *Relay) Run(ctx context.Context) error {
+	for {
+		relayed, err := r.RelayBatch(ctx)
+		if err != nil && ctx.Err() == nil {
+			logger.Errorf("outbox relay failed: %s", err)
+		}
+
+		// keep draining while batches are full
+		if err == nil && relayed == r.config.BatchSize {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(r.config.PollInterval):
+		}
+	}
+}
+
+// RelayBatch claim and publish one batch, it returns the number of records claimed
+func (r *Relay) RelayBatch(ctx context.Context) (int, error) {
+	records, token, err := r.claim()
+	if err != nil || len(records) == 0 {
+		return 0, err
+	}
+
+	var (
+		messages []*eventbusclient.Message
+		indexes  []int
+		failures = map[uint64]error{}
+	)
+	for i := range records {
+		msg, err := records[i].Message()
+		if err != nil {
+			failures[records[i].ID] = err
+			continue
+		}
+		messages = append(messages, msg)
+		indexes = append(indexes, i)
+	}
+
+	var sent []uint64
+	for i, err := range r.producer.PublishBatch(ctx, messages) {
+		id := records[indexes[i]].ID
+		if err != nil {
+			failures[id] = err
+			continue
+		}
+		sent = append(sent, id)
+	}
+
+	if err := r.markSent(sent, token); err != nil {
+		return len(records), err
+	}
+	for id, publishErr := range failures {
+		if err := r.markFailed(id, token, publishErr); err != nil {
+			return len(records), err
+		}
+	}
+
+	return len(records), nil
+}
+
+// claim lock a batch of unsent records under a token unique to this call
+func (r *Relay) claim() ([]Record, string, error) {
+	now := time.Now().UTC()
+	available := r.db.Model(&Record{}).
+		Where("sent_at IS NULL").
+		Where("locked_until IS NULL OR locked_until < ?", now)
+	if r.config.MaxAttempts > 0 {
+		available = available.Where("attempts < ?", r.config.MaxAttempts)
+	}
+
+	var ids []uint64
+	if err := available.Order("id").Limit(r.config.BatchSize).Pluck("id", &ids).Error; err != nil {
+		return nil, "", err
+	}
+	if len(ids) == 0 {
+		return nil, "", nil
+	}
+
+	// another relay may claim the same ids meanwhile, only the rows still free are taken
+	token := fmt.Sprintf("%s-%d", r.config.ID, atomic.AddUint64(&r.sequence, 1))
+	err := r.db.Model(&Record{}).
+		Where("id IN (?)", ids).
+		Where("sent_at IS NULL").
+		Where("locked_until IS NULL OR locked_until < ?", now).
+		Updates(map[string]interface{}{"locked_by": token, "locked_until": now.Add(r.config.LockTimeout)}).Error
+	if err != nil {
+		return nil, "", err
+	}
+
+	var records []Record
+	if err := r.db.Where("locked_by = ?", token).Order("id").Find(&records).Error; err != nil {
+		return nil, "", err
+	}
+
+	return records, token, nil
+}
+
+func (r *Relay) markSent(ids []uint64, token string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	return r.db.Model(&Record{}).
+		Where("id IN (?) AND locked_by = ?", ids, token).
+		Updates(map[string]interface{}{"sent_at": time.Now().UTC(), "locked_by": "", "locked_until": nil}).Error
+}
+
+// markFailed release the record so it is retried on the next poll
+func (r *Relay) markFailed(id uint64, token string, publishErr error) error {
+	return r.db.Model(&Record{}).
+		Where("id = ? AND locked_by = ?", id, token).
+		Updates(map[string]interface{}{
+			"attempts":     gorm.Expr("attempts + 1"),
+			"last_error":   publishErr.Error(),
+			"locked_by":    "",
+			"locked_until": nil,
+		}).Error
+}
@@ -0,0 +1,240 @@
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	eventbusclient "github.com/best-expendables/eventbus-client"
+	"github.com/best-expendables/eventbus-client/codec"
+	"github.com/best-expendables/eventbus-client/producer_manager"
+	"github.com/best-expendables/eventbus-client/transport"
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+)
+
+const testQueue = "package_creation"
+
+func newTestDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// every connection to :memory: is a new database
+	db.DB().SetMaxOpenConns(1)
+	if err := Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func newTestProducer(t *testing.T, broker *transport.MemoryBroker) producer_manager.Producer {
+	p, err := producer_manager.NewProducerWithConfig(&eventbusclient.Config{
+		Transport:            broker,
+		PublishMaxAttempts:   1,
+		PublishRetryInterval: time.Millisecond,
+		Topology:             &eventbusclient.Topology{Queues: []eventbusclient.Queue{{Name: testQueue}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func newTestMessage(id string) *eventbusclient.Message {
+	return &eventbusclient.Message{
+		Id:         id,
+		RoutingKey: testQueue,
+		Header: eventbusclient.Header{
+			Timestamp: time.Now(),
+			Publisher: "package",
+			EventName: "package_creation",
+		},
+		Payload: eventbusclient.Payload{EntityId: "entity", Data: map[string]interface{}{"id": id}},
+	}
+}
+
+func TestStore_FollowsTransaction(t *testing.T) {
+	db := newTestDB(t)
+	defer db.Close()
+
+	tx := db.Begin()
+	if err := Store(tx, newTestMessage("rolled-back")); err != nil {
+		t.Fatal(err)
+	}
+	tx.Rollback()
+
+	tx = db.Begin()
+	if err := Store(tx, newTestMessage("committed")); err != nil {
+		t.Fatal(err)
+	}
+	tx.Commit()
+
+	var records []Record
+	db.Find(&records)
+	if len(records) != 1 || records[0].MessageID != "committed" {
+		t.Fatalf("expect only the committed message, got %+v", records)
+	}
+
+	msg, err := records[0].Message()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Header.EventName != "package_creation" || msg.Payload.EntityId != "entity" {
+		t.Errorf("wrong message: %+v", msg)
+	}
+}
+
+func TestStore_InvalidMessage(t *testing.T) {
+	db := newTestDB(t)
+	defer db.Close()
+
+	msg := newTestMessage("1")
+	msg.Payload.Data = nil
+	if err := Store(db, msg); err == nil {
+		t.Error("expect validation error")
+	}
+}
+
+func TestStore_Codec(t *testing.T) {
+	eventbusclient.RegisterCodec(codec.Msgpack{})
+	db := newTestDB(t)
+	defer db.Close()
+
+	stored := newTestMessage("1")
+	stored.ContentType = codec.ContentTypeMsgpack
+	if err := Store(db, stored); err != nil {
+		t.Fatal(err)
+	}
+
+	var record Record
+	db.First(&record)
+	msg, err := record.Message()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, _ := eventbusclient.EncodePayload(codec.ContentTypeMsgpack, stored.Payload)
+	data, ok := msg.Payload.Data.(eventbusclient.EncodedData)
+	if msg.ContentType != codec.ContentTypeMsgpack || !ok || string(data) != string(expected) {
+		t.Errorf("expect payload kept encoded with msgpack, got %+v", msg)
+	}
+}
+
+func TestRelay_RelayBatch(t *testing.T) {
+	db := newTestDB(t)
+	defer db.Close()
+	broker := transport.NewMemoryBroker()
+	p := newTestProducer(t, broker)
+	defer p.Close()
+
+	for i := 0; i < 3; i++ {
+		if err := Store(db, newTestMessage(fmt.Sprintf("%d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	unroutable := newTestMessage("unroutable")
+	unroutable.RoutingKey = "no_queue_bound"
+	if err := Store(db, unroutable); err != nil {
+		t.Fatal(err)
+	}
+
+	relay := NewRelay(db, p, RelayConfig{})
+	relayed, err := relay.RelayBatch(context.Background())
+	if err != nil || relayed != 4 {
+		t.Fatalf("expect 4 records relayed, got %d: %v", relayed, err)
+	}
+	if broker.MessageCount(testQueue) != 3 {
+		t.Errorf("expect 3 messages in queue, got %d", broker.MessageCount(testQueue))
+	}
+
+	var sent int
+	db.Model(&Record{}).Where("sent_at IS NOT NULL").Count(&sent)
+	if sent != 3 {
+		t.Errorf("expect 3 records marked sent, got %d", sent)
+	}
+
+	var failed Record
+	db.Where("message_id = ?", "unroutable").First(&failed)
+	if failed.SentAt != nil || failed.Attempts != 1 || failed.LastError == "" || failed.LockedUntil != nil {
+		t.Errorf("expect failed record to be released for retry, got %+v", failed)
+	}
+
+	// only the failed record is claimed again
+	if relayed, _ := relay.RelayBatch(context.Background()); relayed != 1 {
+		t.Errorf("expect the failed record to be retried, got %d", relayed)
+	}
+	if relayed, _ := NewRelay(db, p, RelayConfig{MaxAttempts: 2}).RelayBatch(context.Background()); relayed != 0 {
+		t.Errorf("expect record over max attempts to be left, got %d", relayed)
+	}
+}
+
+func TestRelay_ConcurrentRelays(t *testing.T) {
+	db := newTestDB(t)
+	defer db.Close()
+	broker := transport.NewMemoryBroker()
+	p := newTestProducer(t, broker)
+	defer p.Close()
+
+	const total = 50
+	for i := 0; i < total; i++ {
+		if err := Store(db, newTestMessage(fmt.Sprintf("%d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			relay := NewRelay(db, p, RelayConfig{ID: fmt.Sprintf("relay-%d", i), BatchSize: 7})
+			for {
+				relayed, err := relay.RelayBatch(context.Background())
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if relayed == 0 {
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if broker.MessageCount(testQueue) != total {
+		t.Errorf("expect every record published once, got %d messages", broker.MessageCount(testQueue))
+	}
+}
+
+func TestRelay_Run(t *testing.T) {
+	db := newTestDB(t)
+	defer db.Close()
+	broker := transport.NewMemoryBroker()
+	p := newTestProducer(t, broker)
+	defer p.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- NewRelay(db, p, RelayConfig{PollInterval: 10 * time.Millisecond}).Run(ctx)
+	}()
+
+	if err := Store(db, newTestMessage("1")); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for broker.MessageCount(testQueue) != 1 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if broker.MessageCount(testQueue) != 1 {
+		t.Error("expect message stored after start to be relayed")
+	}
+
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("expect Run to stop with the context, got %v", err)
+	}
+}
@@ -0,0 +1,84 @@
+package producer_manager
+
+import (
+	"fmt"
+	"sync"
+
+	eventbusclient "github.com/best-expendables/eventbus-client"
+	"github.com/best-expendables/eventbus-client/transport"
+	"github.com/streadway/amqp"
+)
+
+const defaultChannelPoolSize = 4
+
+// publisherChannel confirm mode channel of the producer pool.
+// Publishings on it are serialized so their delivery tags follow the broker counter
+type publisherChannel struct {
+	mu      sync.Mutex
+	channel transport.Channel
+	tracker *confirmTracker
+	dead    bool
+}
+
+func openPublisherChannel(con transport.Connection, topology *eventbusclient.Topology) (*publisherChannel, error) {
+	channel, err := con.Channel()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := topology.Declare(channel); err != nil {
+		return nil, err
+	}
+
+	if err := channel.Confirm(false); err != nil {
+		return nil, fmt.Errorf("channel could not be put into confirm mode: %s", err)
+	}
+
+	return &publisherChannel{
+		channel: channel,
+		tracker: newConfirmTracker(
+			channel.NotifyPublish(make(chan amqp.Confirmation, 1)),
+			channel.NotifyReturn(make(chan amqp.Return, 1)),
+		),
+	}, nil
+}
+
+// send publish without waiting for the confirm
+func (c *publisherChannel) send(exchange, routingKey string, msg amqp.Publishing) (*pendingConfirm, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	tag, result := c.tracker.reserve()
+	// copied, the headers of the caller must not keep the tag
+	headers := make(amqp.Table, len(msg.Headers)+1)
+	for key, value := range msg.Headers {
+		headers[key] = value
+	}
+	headers[eventbusclient.PublishTagHeader] = int64(tag)
+	msg.Headers = headers
+	if err := c.channel.Publish(exchange, routingKey, true, false, msg); err != nil {
+		c.tracker.release(tag)
+		if err == amqp.ErrClosed {
+			c.dead = true
+		}
+		return nil, err
+	}
+
+	return &pendingConfirm{tracker: c.tracker, tag: tag, result: result}, nil
+}
+
+// isDead the channel was closed, by the broker or with its connection
+func (c *publisherChannel) isDead() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.dead || c.tracker.isClosed()
+}
+
+func (c *publisherChannel) close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.dead = true
+	return c.channel.Close()
+}
@@ -0,0 +1,180 @@
+package producer_manager
+
+import (
+	"context"
+	"sync"
+
+	eventbusclient "github.com/best-expendables/eventbus-client"
+	"github.com/best-expendables/logger"
+	"github.com/streadway/amqp"
+)
+
+// confirmTracker resolve publishings of one confirm mode channel from the broker acks, nacks and returns
+type confirmTracker struct {
+	mu        sync.Mutex
+	published uint64
+	confirmed uint64
+	pending   map[uint64]chan error
+	returned  map[uint64]*UnroutableError
+	closed    bool
+}
+
+// pendingConfirm publishing sent and waiting for its confirm
+type pendingConfirm struct {
+	tracker *confirmTracker
+	tag     uint64
+	result  <-chan error
+}
+
+func newConfirmTracker(confirms <-chan amqp.Confirmation, returns <-chan amqp.Return) *confirmTracker {
+	t := &confirmTracker{
+		pending:  map[uint64]chan error{},
+		returned: map[uint64]*UnroutableError{},
+	}
+	go t.listen(confirms, returns)
+
+	return t
+}
+
+// reserve the delivery tag of the next publishing, registered before sending so a fast confirm is not missed.
+// Publishings must be sent in the order of their tags, with the tag in their PublishTagHeader
+func (t *confirmTracker) reserve() (uint64, <-chan error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.published++
+	result := make(chan error, 1)
+	if t.closed {
+		result <- ErrMessageSendConfirmFailed
+		return t.published, result
+	}
+	t.pending[t.published] = result
+
+	return t.published, result
+}
+
+// release a reserved tag whose publishing could not be sent
+func (t *confirmTracker) release(tag uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.pending, tag)
+	if t.published == tag {
+		t.published--
+	}
+}
+
+// forget stop waiting for a publishing, its confirm is dropped when it arrives
+func (t *confirmTracker) forget(tag uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.pending, tag)
+}
+
+func (t *confirmTracker) isClosed() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.closed
+}
+
+func (c *pendingConfirm) wait(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		c.tracker.forget(c.tag)
+		return ctx.Err()
+	case err := <-c.result:
+		return err
+	}
+}
+
+// listen always drain both channels, an unread notification would block the whole connection
+func (t *confirmTracker) listen(confirms <-chan amqp.Confirmation, returns <-chan amqp.Return) {
+	for {
+		select {
+		case returned, ok := <-returns:
+			if !ok {
+				returns = nil
+				continue
+			}
+			t.addReturn(returned)
+		case confirmed, ok := <-confirms:
+			if !ok {
+				t.close()
+				return
+			}
+			// the broker sends the return before the confirm of the same publishing
+			t.drainReturns(returns)
+			t.resolve(confirmed)
+		}
+	}
+}
+
+func (t *confirmTracker) drainReturns(returns <-chan amqp.Return) {
+	for {
+		select {
+		case returned, ok := <-returns:
+			if !ok {
+				return
+			}
+			t.addReturn(returned)
+		default:
+			return
+		}
+	}
+}
+
+// addReturn match the return with the unconfirmed publishing of its PublishTagHeader, it is left unmatched
+// when the header is missing, rather than guessed from message ids which are optional and reused by retries
+func (t *confirmTracker) addReturn(returned amqp.Return) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	tag := uint64(eventbusclient.HeaderInt64(returned.Headers[eventbusclient.PublishTagHeader]))
+	if tag <= t.confirmed || tag > t.published {
+		logger.Errorf("return of message %s to %s with routing key %s matches no publishing", returned.MessageId, returned.Exchange, returned.RoutingKey)
+		return
+	}
+	t.returned[tag] = newUnroutableError(returned)
+}
+
+// resolve every publishing up to the confirmed tag, a confirm with the multiple flag covers all previous ones
+func (t *confirmTracker) resolve(confirmed amqp.Confirmation) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for tag := t.confirmed + 1; tag <= confirmed.DeliveryTag; tag++ {
+		returned := t.returned[tag]
+		delete(t.returned, tag)
+		result, ok := t.pending[tag]
+		if !ok {
+			continue
+		}
+		delete(t.pending, tag)
+
+		switch {
+		case !confirmed.Ack:
+			result <- ErrMessageNotAcked
+		case returned != nil:
+			result <- returned
+		default:
+			result <- nil
+		}
+	}
+	if confirmed.DeliveryTag > t.confirmed {
+		t.confirmed = confirmed.DeliveryTag
+	}
+}
+
+// close fail every publishing still waiting, the channel is gone with their confirms
+func (t *confirmTracker) close() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.closed = true
+	for tag, result := range t.pending {
+		result <- ErrMessageSendConfirmFailed
+		delete(t.pending, tag)
+	}
+}
@@ -0,0 +1,77 @@
+package producer_manager
+
+import (
+	"fmt"
+
+	"github.com/streadway/amqp"
+)
+
+// PublishError returned when the producer gives up publishing a message
+type PublishError struct {
+	Exchange   string
+	RoutingKey string
+	Attempts   int
+	// Err why the producer gave up: the context error when it is done, the last attempt error otherwise
+	Err error
+	// LastErr error of the last attempt, nil when the context was done before the first one
+	LastErr error
+}
+
+func newPublishError(exchange, routingKey string, attempts int, lastErr, ctxErr error) *PublishError {
+	err := &PublishError{
+		Exchange:   exchange,
+		RoutingKey: routingKey,
+		Attempts:   attempts,
+		Err:        lastErr,
+		LastErr:    lastErr,
+	}
+	if ctxErr != nil {
+		err.Err = ctxErr
+	}
+
+	return err
+}
+
+func (e *PublishError) Error() string {
+	msg := fmt.Sprintf("publish to exchange %q with routing key %q failed after %d attempt(s): %s", e.Exchange, e.RoutingKey, e.Attempts, e.Err)
+	if e.LastErr != nil && e.LastErr != e.Err {
+		msg = fmt.Sprintf("%s, last error: %s", msg, e.LastErr)
+	}
+	return msg
+}
+
+// Unwrap support errors.Is(err, ErrMessageNotAcked) and the like
+func (e *PublishError) Unwrap() error {
+	return e.Err
+}
+
+// Cause support github.com/pkg/errors.Cause
+func (e *PublishError) Cause() error {
+	return e.Err
+}
+
+// UnroutableError the broker returned a mandatory publishing because no queue is bound for it
+type UnroutableError struct {
+	Exchange   string
+	RoutingKey string
+	ReplyCode  uint16
+	ReplyText  string
+}
+
+func newUnroutableError(returned amqp.Return) *UnroutableError {
+	return &UnroutableError{
+		Exchange:   returned.Exchange,
+		RoutingKey: returned.RoutingKey,
+		ReplyCode:  returned.ReplyCode,
+		ReplyText:  returned.ReplyText,
+	}
+}
+
+func (e *UnroutableError) Error() string {
+	return fmt.Sprintf("message to exchange %q with routing key %q is unroutable: %d %s", e.Exchange, e.RoutingKey, e.ReplyCode, e.ReplyText)
+}
+
+// Is make errors.Is(err, ErrMessageUnroutable) true
+func (e *UnroutableError) Is(target error) bool {
+	return target == ErrMessageUnroutable
+}
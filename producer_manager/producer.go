@@ -2,13 +2,14 @@ package producer_manager
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	eventbusclient "github.com/best-expendables/eventbus-client"
+	"github.com/best-expendables/eventbus-client/transport"
 	"github.com/best-expendables/logger"
 	"github.com/streadway/amqp"
 	"gopkg.in/go-playground/validator.v9"
@@ -19,30 +20,54 @@ var (
 	ErrMessageSendConfirmFailed = errors.New("cannot get confirm, refreshed producer_manager")
 	//ErrMessageNotAcked message is not acked by rabbitmq
 	ErrMessageNotAcked = errors.New("RMQ did not ack the message")
+	//ErrMessageUnroutable message is returned by rabbitmq, no queue is bound for its routing key
+	ErrMessageUnroutable = errors.New("message is unroutable")
 )
 
 const (
 	retryDelaySeconds = 2
 )
 
+// UnroutableHandler called when a message is returned as unroutable, Publish returns its error.
+// It can save the message elsewhere (alternate exchange, local file) and return nil to report success
+type UnroutableHandler func(ctx context.Context, message *eventbusclient.Message, err *UnroutableError) error
+
+// PublishResult outcome of an asynchronous publishing
+type PublishResult struct {
+	Message *eventbusclient.Message
+	Err     error
+}
+
 // Producer set middlewares and Publish message to eventbus
 type Producer interface {
 	Use(middleware ...PublishFuncMiddleware)
+	OnUnroutable(handler UnroutableHandler)
 	Publish(ctx context.Context, message *eventbusclient.Message) error
+	PublishAsync(ctx context.Context, message *eventbusclient.Message) <-chan PublishResult
+	PublishBatch(ctx context.Context, messages []*eventbusclient.Message) []error
 	PublishRaw(ctx context.Context, message *eventbusclient.Message) error
+	Connected() bool
 	Close() error
 }
 
+// producer is safe for concurrent use, publishings are spread over a pool of confirm mode channels
 type producer struct {
 	url         string
-	con         *amqp.Connection
-	channel     *amqp.Channel
-	confirm     chan amqp.Confirmation
+	transport   transport.Transport
+	con         transport.Connection
+	channels    []*publisherChannel
+	poolSize    int
+	next        uint32
+	retryPolicy RetryPolicy
+	metrics     eventbusclient.MetricsSink
+	compressor  eventbusclient.Compressor
+	threshold   int
+	unroutable  UnroutableHandler
+	topology    *eventbusclient.Topology
 	validate    *validator.Validate
 	middlewares []PublishFuncMiddleware
-	locker      *sync.Mutex
+	locker      sync.RWMutex
 	closed      bool
-	isConnected bool
 }
 
 // NewProducer create new producer_manager, autoload config data from system environment
@@ -54,9 +79,24 @@ func NewProducer() (Producer, error) {
 
 func NewProducerWithConfig(config *eventbusclient.Config) (Producer, error) {
 	producer := &producer{
-		url:      config.GetURL(),
-		validate: validator.New(),
-		locker:   new(sync.Mutex),
+		url:         config.GetURL(),
+		transport:   config.GetTransport(),
+		topology:    config.Topology,
+		poolSize:    config.PublisherChannelPoolSize,
+		retryPolicy: NewRetryPolicyFromConfig(config),
+		metrics:     config.GetMetrics(),
+		threshold:   config.CompressionThreshold,
+		validate:    validator.New(),
+	}
+	if producer.poolSize <= 0 {
+		producer.poolSize = defaultChannelPoolSize
+	}
+	if config.Compression != "" {
+		compressor, err := eventbusclient.LookupCompressor(config.Compression)
+		if err != nil {
+			return nil, err
+		}
+		producer.compressor = compressor
 	}
 	producer.Use(PublishMessageLogMiddleware)
 
@@ -68,157 +108,365 @@ func NewProducerWithConfig(config *eventbusclient.Config) (Producer, error) {
 }
 
 func (p *producer) Use(middleWares ...PublishFuncMiddleware) {
+	p.locker.Lock()
+	defer p.locker.Unlock()
 	p.middlewares = append(p.middlewares, middleWares...)
 }
 
+func (p *producer) OnUnroutable(handler UnroutableHandler) {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	p.unroutable = handler
+}
+
+func (p *producer) chain(head PublishFunc) PublishFunc {
+	p.locker.RLock()
+	defer p.locker.RUnlock()
+
+	return makePublisherMiddlewareChain(p.middlewares, head)
+}
+
 func (p *producer) createConnection() error {
 	p.locker.Lock()
 	defer p.locker.Unlock()
 
-	if p.isConnected {
+	return p.connect()
+}
+
+// connect dial the broker and open the channel pool, the caller must hold the lock
+func (p *producer) connect() error {
+	if p.con != nil && !p.con.IsClosed() {
 		return nil
 	}
 
-	var err error
 	if p.con != nil {
 		_ = p.con.Close()
 	}
-	p.con, err = amqp.Dial(p.url)
+	con, err := p.transport.Dial(p.url)
 	if err != nil {
 		return err
 	}
 
-	p.channel, err = p.con.Channel()
-	if err != nil {
-		return err
+	channels := make([]*publisherChannel, p.poolSize)
+	for i := range channels {
+		// the topology is declared once per connection
+		topology := p.topology
+		if i > 0 {
+			topology = nil
+		}
+		if channels[i], err = openPublisherChannel(con, topology); err != nil {
+			_ = con.Close()
+			return err
+		}
 	}
+	p.con = con
+	p.channels = channels
 
-	if err := p.channel.Confirm(false); err != nil {
-		return fmt.Errorf("channel could not be put into confirm mode: %s", err)
-	}
+	notifyClose := con.NotifyClose(make(chan *amqp.Error, 1))
+	go p.reconnectOnClose(con, notifyClose)
 
-	p.confirm = p.channel.NotifyPublish(make(chan amqp.Confirmation, 1))
+	return nil
+}
 
-	notifyClose := p.con.NotifyClose(make(chan *amqp.Error, 1))
-	go func(closeChannel chan *amqp.Error) {
-		closeErr := <-closeChannel
-		p.isConnected = false
-		logger.Errorf("connection closed by ", closeErr)
-		if p.closed {
+func (p *producer) reconnectOnClose(con transport.Connection, closeChannel chan *amqp.Error) {
+	closeErr := <-closeChannel
+	logger.Errorf("connection closed by ", closeErr)
+	for {
+		p.locker.Lock()
+		// closed on purpose, or already replaced while publishing
+		if p.closed || p.con != con {
+			p.locker.Unlock()
 			return
 		}
-		for {
-			logger.Info("reconnecting")
-			err = p.createConnection()
-			if err == nil {
-				logger.Info("reconnected")
-				break
-			}
-			logger.Infof("reconnect failed, reason: %s", err)
-			time.Sleep(retryDelaySeconds * time.Second)
+		logger.Info("reconnecting")
+		err := p.connect()
+		p.locker.Unlock()
+		if err == nil {
+			logger.Info("reconnected")
+			p.metrics.Reconnected(eventbusclient.ComponentProducer)
+			return
+		}
+		logger.Infof("reconnect failed, reason: %s", err)
+		time.Sleep(retryDelaySeconds * time.Second)
+	}
+}
+
+// refreshChannels replace channels closed by the broker, dialing again when the whole connection is gone
+func (p *producer) refreshChannels() error {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	if p.closed {
+		return amqp.ErrClosed
+	}
+	if p.con == nil || p.con.IsClosed() {
+		return p.connect()
+	}
+	for i, channel := range p.channels {
+		if !channel.isDead() {
+			continue
+		}
+		reopened, err := openPublisherChannel(p.con, nil)
+		if err != nil {
+			return err
 		}
-	}(notifyClose)
-	p.isConnected = true
+		p.channels[i] = reopened
+	}
 
 	return nil
 }
 
+// pick the next channel of the pool, round robin
+func (p *producer) pick() (*publisherChannel, error) {
+	p.locker.RLock()
+	defer p.locker.RUnlock()
+
+	if p.closed || len(p.channels) == 0 {
+		return nil, amqp.ErrClosed
+	}
+	i := atomic.AddUint32(&p.next, 1)
+
+	return p.channels[int(i)%len(p.channels)], nil
+}
+
 func (p *producer) Publish(ctx context.Context, msg *eventbusclient.Message) error {
 	if err := p.validate.Struct(*msg); err != nil {
 		return err
 	}
 
-	err := makePublisherMiddlewareChain(p.middlewares, p.publish)(ctx, msg)
-	if IsConnectionClosedError(err) {
-		_ = p.createConnection()
-		err = p.publish(ctx, msg)
-	}
-
-	return err
+	return p.chain(p.publish)(ctx, msg)
 }
 
-func (p *producer) publish(_ context.Context, msg *eventbusclient.Message) error {
-	body, err := json.Marshal(msg.Payload)
+func (p *producer) publish(ctx context.Context, msg *eventbusclient.Message) error {
+	publishing, err := p.newPublishing(msg)
 	if err != nil {
 		return err
 	}
 
-	publishing := amqp.Publishing{
-		MessageId:    msg.Id,
-		Headers:      amqp.Table(msg.Header.ToMap()),
-		ContentType:  "application/json",
-		DeliveryMode: amqp.Persistent,
-		Body:         body,
+	return p.handleUnroutable(ctx, msg, p.publishWithRetry(ctx, msg.Exchange, msg.RoutingKey, publishing))
+}
+
+// PublishAsync publish without waiting for the broker confirm, the result is sent once on the returned channel.
+// Middlewares run before sending, they see the sending error but not the confirm
+func (p *producer) PublishAsync(ctx context.Context, msg *eventbusclient.Message) <-chan PublishResult {
+	results := make(chan PublishResult, 1)
+	if err := p.validate.Struct(*msg); err != nil {
+		results <- PublishResult{Message: msg, Err: err}
+		return results
 	}
 
-	err = p.publishWithConfirm(msg.Exchange, msg.RoutingKey, publishing)
-	for err != nil {
-		if err == amqp.ErrClosed {
-			_ = p.createConnection()
-		} else if err == ErrMessageSendConfirmFailed {
-			_ = p.createConnection()
-		} else if err == ErrMessageNotAcked {
+	var (
+		publishing amqp.Publishing
+		confirm    *pendingConfirm
+		sendErr    error
+	)
+	err := p.chain(func(ctx context.Context, msg *eventbusclient.Message) error {
+		var err error
+		if publishing, err = p.newPublishing(msg); err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return newPublishError(msg.Exchange, msg.RoutingKey, 0, nil, err)
 		}
-		err = p.publishWithConfirm(msg.Exchange, msg.RoutingKey, publishing)
+		// a failed send is retried in background like a failed confirm
+		confirm, sendErr = p.send(msg.Exchange, msg.RoutingKey, publishing)
+		return nil
+	})(ctx, msg)
+	if err != nil {
+		results <- PublishResult{Message: msg, Err: err}
+		return results
 	}
 
-	return nil
+	go func() {
+		err := sendErr
+		if confirm != nil {
+			err = confirm.wait(ctx)
+		}
+		err = p.retry(ctx, msg.Exchange, msg.RoutingKey, publishing, 1, err)
+		results <- PublishResult{Message: msg, Err: p.handleUnroutable(ctx, msg, err)}
+	}()
+
+	return results
+}
+
+// PublishBatch publish all messages keeping them in flight together, errors are returned in the messages order
+func (p *producer) PublishBatch(ctx context.Context, msgs []*eventbusclient.Message) []error {
+	results := make([]<-chan PublishResult, len(msgs))
+	for i, msg := range msgs {
+		results[i] = p.PublishAsync(ctx, msg)
+	}
+
+	errs := make([]error, len(msgs))
+	for i, result := range results {
+		errs[i] = (<-result).Err
+	}
+
+	return errs
 }
 
 func (p *producer) PublishRaw(ctx context.Context, msg *eventbusclient.Message) error {
-	return makePublisherMiddlewareChain(p.middlewares, p.publishRaw)(ctx, msg)
+	return p.chain(p.publishRaw)(ctx, msg)
 }
 
 func (p *producer) publishRaw(ctx context.Context, msg *eventbusclient.Message) error {
-	body, err := json.Marshal(msg.Payload.Data)
+	codec, err := eventbusclient.LookupCodec(msg.ContentType)
+	if err != nil {
+		return err
+	}
+	body, err := codec.Marshal(msg.Payload.Data)
 	if err != nil {
 		return err
 	}
 
 	publishing := amqp.Publishing{
 		MessageId:    msg.Id,
-		ContentType:  "application/json",
+		ContentType:  codec.ContentType(),
 		DeliveryMode: amqp.Persistent,
 		Body:         body,
 	}
 
-	err = p.publishWithConfirm(msg.Exchange, msg.RoutingKey, publishing)
-	for err != nil {
-		if err == amqp.ErrClosed {
-			_ = p.createConnection()
-		} else if err == ErrMessageSendConfirmFailed {
-			_ = p.createConnection()
-		} else if err == ErrMessageNotAcked {
+	return p.handleUnroutable(ctx, msg, p.publishWithRetry(ctx, msg.Exchange, msg.RoutingKey, publishing))
+}
+
+// newPublishing encode the message, the body is compressed when the producer has a compressor and it is large enough
+func (p *producer) newPublishing(msg *eventbusclient.Message) (amqp.Publishing, error) {
+	body, err := eventbusclient.EncodePayload(msg.ContentType, msg.Payload)
+	if err != nil {
+		return amqp.Publishing{}, err
+	}
+
+	headers := amqp.Table(msg.Header.ToMap())
+	contentType := msg.ContentType
+	if contentType == "" {
+		contentType = eventbusclient.ContentTypeJSON
+	}
+	if contentType != eventbusclient.ContentTypeJSON {
+		headers[eventbusclient.EntityIdHeader] = msg.Payload.EntityId
+	}
+
+	var contentEncoding string
+	if p.compressor != nil && len(body) >= p.threshold {
+		if body, err = p.compressor.Compress(body); err != nil {
+			return amqp.Publishing{}, err
 		}
-		err = p.publishWithConfirm(msg.Exchange, msg.RoutingKey, publishing)
+		contentEncoding = p.compressor.Encoding()
 	}
 
-	return nil
+	return amqp.Publishing{
+		MessageId:       msg.Id,
+		Headers:         headers,
+		ContentType:     contentType,
+		ContentEncoding: contentEncoding,
+		DeliveryMode:    amqp.Persistent,
+		Body:            body,
+	}, nil
 }
 
-func (p *producer) publishWithConfirm(exchange, routingKey string, msg amqp.Publishing) error {
-	if err := p.channel.Publish(exchange, routingKey, true, false, msg); err != nil {
+func (p *producer) handleUnroutable(ctx context.Context, msg *eventbusclient.Message, err error) error {
+	p.locker.RLock()
+	handler := p.unroutable
+	p.locker.RUnlock()
+
+	var unroutable *UnroutableError
+	if handler == nil || !errors.As(err, &unroutable) {
 		return err
 	}
-	select {
-	case confirmed, ok := <-p.confirm:
-		if !ok {
-			return ErrMessageSendConfirmFailed
+
+	return handler(ctx, msg, unroutable)
+}
+
+// publishWithRetry publish until the broker confirms the message, the retry policy gives up or ctx is done
+func (p *producer) publishWithRetry(ctx context.Context, exchange, routingKey string, msg amqp.Publishing) error {
+	if err := ctx.Err(); err != nil {
+		return newPublishError(exchange, routingKey, 0, nil, err)
+	}
+
+	return p.retry(ctx, exchange, routingKey, msg, 1, p.publishWithConfirm(ctx, exchange, routingKey, msg))
+}
+
+// retry publish again after `attempt` attempts, the last one failing with err
+func (p *producer) retry(ctx context.Context, exchange, routingKey string, msg amqp.Publishing, attempt int, err error) error {
+	for err != nil {
+		if _, unroutable := err.(*UnroutableError); unroutable || err == ctx.Err() || attempt >= p.retryPolicy.MaxAttempts {
+			return newPublishError(exchange, routingKey, attempt, err, ctx.Err())
 		}
-		if !confirmed.Ack {
-			return ErrMessageNotAcked
+		// publishings in flight when the producer is closed are not retried
+		if p.isClosed() {
+			return newPublishError(exchange, routingKey, attempt, err, ctx.Err())
 		}
-		return nil
+
+		if err == amqp.ErrClosed || err == ErrMessageSendConfirmFailed {
+			if refreshErr := p.refreshChannels(); refreshErr != nil {
+				logger.Infof("refresh publisher channel failed, reason: %s", refreshErr)
+			}
+		}
+
+		p.metrics.PublishRetried(exchange, routingKey)
+		timer := time.NewTimer(p.retryPolicy.Backoff(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return newPublishError(exchange, routingKey, attempt, err, ctx.Err())
+		case <-timer.C:
+		}
+
+		attempt++
+		err = p.publishWithConfirm(ctx, exchange, routingKey, msg)
 	}
+
+	return nil
 }
 
+func (p *producer) publishWithConfirm(ctx context.Context, exchange, routingKey string, msg amqp.Publishing) error {
+	confirm, err := p.send(exchange, routingKey, msg)
+	if err != nil {
+		return err
+	}
+
+	return confirm.wait(ctx)
+}
+
+// send publish on a channel of the pool without waiting for the confirm
+func (p *producer) send(exchange, routingKey string, msg amqp.Publishing) (*pendingConfirm, error) {
+	channel, err := p.pick()
+	if err != nil {
+		return nil, err
+	}
+
+	return channel.send(exchange, routingKey, msg)
+}
+
+func (p *producer) isClosed() bool {
+	p.locker.RLock()
+	defer p.locker.RUnlock()
+
+	return p.closed
+}
+
+// Connected whether the connection to the broker is open, false while reconnecting and once closed
+func (p *producer) Connected() bool {
+	p.locker.RLock()
+	defer p.locker.RUnlock()
+
+	return !p.closed && p.con != nil && !p.con.IsClosed()
+}
+
+// Close the channels and the connection, closing again does nothing
 func (p *producer) Close() error {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	if p.closed {
+		return nil
+	}
 	p.closed = true
-	if err := p.channel.Close(); err != nil {
-		return fmt.Errorf("close publisher channel fails: %s", err)
+	for _, channel := range p.channels {
+		if err := channel.close(); err != nil && err != amqp.ErrClosed {
+			return fmt.Errorf("close publisher channel fails: %s", err)
+		}
 	}
 
-	if err := p.con.Close(); err != nil {
+	if err := p.con.Close(); err != nil && err != amqp.ErrClosed {
 		return fmt.Errorf("close publisher connection fails: %s", err)
 	}
 
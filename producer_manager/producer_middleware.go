@@ -2,6 +2,7 @@ package producer_manager
 
 import (
 	"context"
+	"time"
 
 	eventbusclient "github.com/best-expendables/eventbus-client"
 	"github.com/best-expendables/eventbus-client/helper"
@@ -42,6 +43,20 @@ func AttachTraceId() PublishFuncMiddleware {
 	}
 }
 
+// PublishMetrics report every publishing and its latency to the sink.
+// Publish is measured until confirmed, retries included, PublishAsync until sent
+func PublishMetrics(sink eventbusclient.MetricsSink) PublishFuncMiddleware {
+	return func(next PublishFunc) PublishFunc {
+		return func(ctx context.Context, message *eventbusclient.Message) error {
+			start := time.Now()
+			err := next(ctx, message)
+			sink.MessagePublished(message.Exchange, message.RoutingKey, message.Header.EventName, err, time.Since(start))
+
+			return err
+		}
+	}
+}
+
 func makePublisherMiddlewareChain(middleWares []PublishFuncMiddleware, head PublishFunc) PublishFunc {
 	total := len(middleWares)
 	if total == 0 {
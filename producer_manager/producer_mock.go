@@ -8,10 +8,14 @@ import (
 
 // ProducerMock producer_manager mock
 type ProducerMock struct {
-	UseFn        func(middleware ...PublishFuncMiddleware)
-	PublishFn    func(ctx context.Context, message *eventbusclient.Message) error
-	PublishRawFn func(ctx context.Context, message *eventbusclient.Message) error
-	CloseFn      func() error
+	UseFn          func(middleware ...PublishFuncMiddleware)
+	OnUnroutableFn func(handler UnroutableHandler)
+	PublishFn      func(ctx context.Context, message *eventbusclient.Message) error
+	PublishAsyncFn func(ctx context.Context, message *eventbusclient.Message) <-chan PublishResult
+	PublishBatchFn func(ctx context.Context, messages []*eventbusclient.Message) []error
+	PublishRawFn   func(ctx context.Context, message *eventbusclient.Message) error
+	ConnectedFn    func() bool
+	CloseFn        func() error
 }
 
 // Use method mock
@@ -19,15 +23,34 @@ func (m ProducerMock) Use(middleware ...PublishFuncMiddleware) {
 	m.UseFn(middleware...)
 }
 
+// OnUnroutable method mock
+func (m ProducerMock) OnUnroutable(handler UnroutableHandler) {
+	m.OnUnroutableFn(handler)
+}
+
 // Publish method mock
 func (m ProducerMock) Publish(ctx context.Context, message *eventbusclient.Message) error {
 	return m.PublishFn(ctx, message)
 }
 
+// PublishAsync method mock
+func (m ProducerMock) PublishAsync(ctx context.Context, message *eventbusclient.Message) <-chan PublishResult {
+	return m.PublishAsyncFn(ctx, message)
+}
+
+// PublishBatch method mock
+func (m ProducerMock) PublishBatch(ctx context.Context, messages []*eventbusclient.Message) []error {
+	return m.PublishBatchFn(ctx, messages)
+}
+
 func (m ProducerMock) PublishRaw(ctx context.Context, message *eventbusclient.Message) error {
 	return m.PublishRawFn(ctx, message)
 }
 
+func (m ProducerMock) Connected() bool {
+	return m.ConnectedFn()
+}
+
 func (m ProducerMock) Close() error {
 	return m.CloseFn()
 }
@@ -0,0 +1,598 @@
+package producer_manager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	eventbusclient "github.com/best-expendables/eventbus-client"
+	"github.com/best-expendables/eventbus-client/helper"
+	"github.com/best-expendables/eventbus-client/transport"
+	"github.com/streadway/amqp"
+)
+
+const testQueue = "package_creation"
+
+func newTestProducer(t *testing.T, broker *transport.MemoryBroker) Producer {
+	p, err := NewProducerWithConfig(&eventbusclient.Config{
+		Transport: broker,
+		Topology:  &eventbusclient.Topology{Queues: []eventbusclient.Queue{{Name: testQueue, Durable: true}}},
+	})
+	if err != nil {
+		t.Fatalf("create producer: %s", err)
+	}
+	return p
+}
+
+func newTestMessage(id string) *eventbusclient.Message {
+	return &eventbusclient.Message{
+		Id:         id,
+		RoutingKey: testQueue,
+		Header: eventbusclient.Header{
+			Timestamp: time.Now(),
+			Publisher: "package",
+			EventName: "package_creation",
+		},
+		Payload: eventbusclient.Payload{EntityId: "entity", Data: map[string]interface{}{"id": id}},
+	}
+}
+
+func consumeOne(t *testing.T, broker *transport.MemoryBroker) amqp.Delivery {
+	conn, err := broker.Dial("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	ch, _ := conn.Channel()
+	deliveries, err := ch.Consume(testQueue, "", true, false, false, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case d := <-deliveries:
+		return d
+	case <-time.After(time.Second):
+		t.Fatal("no message published")
+	}
+	return amqp.Delivery{}
+}
+
+func TestProducer_Publish(t *testing.T) {
+	broker := transport.NewMemoryBroker()
+	p := newTestProducer(t, broker)
+	defer p.Close()
+
+	if err := p.Publish(context.Background(), newTestMessage("1")); err != nil {
+		t.Fatalf("publish failed: %s", err)
+	}
+
+	d := consumeOne(t, broker)
+	if d.MessageId != "1" || d.ContentType != "application/json" || d.Headers["eventName"] != "package_creation" {
+		t.Errorf("wrong delivery: %+v", d)
+	}
+	msg, err := helper.GetMessageFromDelivery(d)
+	if err != nil {
+		t.Fatalf("decode failed: %s", err)
+	}
+	if _, ok := msg.Header.Extra[eventbusclient.PublishTagHeader]; ok {
+		t.Errorf("expect the publish tag dropped on consume, got: %v", msg.Header.Extra)
+	}
+}
+
+func TestProducer_PublishAfterConnectionDrop(t *testing.T) {
+	broker := transport.NewMemoryBroker()
+	p := newTestProducer(t, broker)
+	defer p.Close()
+
+	broker.DropConnections()
+
+	if err := p.Publish(context.Background(), newTestMessage("1")); err != nil {
+		t.Fatalf("publish failed: %s", err)
+	}
+	if d := consumeOne(t, broker); d.MessageId != "1" {
+		t.Errorf("wrong delivery: %+v", d)
+	}
+}
+
+func TestProducer_CloseTwice(t *testing.T) {
+	p := newTestProducer(t, transport.NewMemoryBroker())
+
+	if err := p.Close(); err != nil {
+		t.Fatalf("close failed: %s", err)
+	}
+	if err := p.Close(); err != nil {
+		t.Errorf("expect closing again to do nothing, got: %s", err)
+	}
+	if p.Connected() {
+		t.Error("expect closed producer to be disconnected")
+	}
+}
+
+func TestProducer_PublishInvalidMessage(t *testing.T) {
+	p := newTestProducer(t, transport.NewMemoryBroker())
+	defer p.Close()
+
+	msg := newTestMessage("1")
+	msg.Payload.Data = nil
+	if err := p.Publish(context.Background(), msg); err == nil {
+		t.Error("expect validation error")
+	}
+}
+
+func TestProducer_PublishGivesUp(t *testing.T) {
+	broker := transport.NewMemoryBroker()
+	p, err := NewProducerWithConfig(&eventbusclient.Config{
+		Transport:            broker,
+		PublishMaxAttempts:   3,
+		PublishRetryInterval: time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	msg := newTestMessage("1")
+	msg.Exchange = "missing_exchange"
+	err = p.Publish(context.Background(), msg)
+
+	publishErr, ok := err.(*PublishError)
+	if !ok {
+		t.Fatalf("expect PublishError, got: %v", err)
+	}
+	if publishErr.Attempts != 3 || !errors.Is(err, ErrMessageSendConfirmFailed) {
+		t.Errorf("wrong error: %s", err)
+	}
+}
+
+func TestProducer_PublishHonoursContext(t *testing.T) {
+	broker := transport.NewMemoryBroker()
+	p := newTestProducer(t, broker)
+	defer p.Close()
+
+	broker.SetDialError(errors.New("broker is down"))
+	broker.DropConnections()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := p.Publish(ctx, newTestMessage("1"))
+
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expect deadline exceeded, got: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("publish must stop at the context deadline, took %s", elapsed)
+	}
+}
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	policy := NewRetryPolicyFromConfig(&eventbusclient.Config{
+		PublishRetryInterval:    100 * time.Millisecond,
+		PublishRetryMaxInterval: time.Second,
+	})
+	if policy.MaxAttempts != defaultMaxAttempts {
+		t.Errorf("expect default max attempts, got: %d", policy.MaxAttempts)
+	}
+
+	var cases = []struct {
+		attempt  int
+		min, max time.Duration
+	}{
+		{attempt: 1, min: 50 * time.Millisecond, max: 100 * time.Millisecond},
+		{attempt: 2, min: 100 * time.Millisecond, max: 200 * time.Millisecond},
+		{attempt: 4, min: 400 * time.Millisecond, max: 800 * time.Millisecond},
+		{attempt: 10, min: 500 * time.Millisecond, max: time.Second},
+	}
+	for _, c := range cases {
+		for i := 0; i < 20; i++ {
+			if d := policy.Backoff(c.attempt); d < c.min || d > c.max {
+				t.Errorf("attempt %d: backoff %s out of [%s, %s]", c.attempt, d, c.min, c.max)
+			}
+		}
+	}
+}
+
+func TestProducer_PublishUnroutable(t *testing.T) {
+	p := newTestProducer(t, transport.NewMemoryBroker())
+	defer p.Close()
+
+	msg := newTestMessage("1")
+	msg.RoutingKey = "no_queue_bound"
+	err := p.Publish(context.Background(), msg)
+
+	if !errors.Is(err, ErrMessageUnroutable) {
+		t.Fatalf("expect unroutable error, got: %v", err)
+	}
+	var unroutable *UnroutableError
+	if !errors.As(err, &unroutable) {
+		t.Fatalf("expect UnroutableError, got: %T", err)
+	}
+	if unroutable.RoutingKey != "no_queue_bound" || unroutable.ReplyText != "NO_ROUTE" {
+		t.Errorf("wrong unroutable error: %+v", unroutable)
+	}
+	if err.(*PublishError).Attempts != 1 {
+		t.Error("unroutable message must not be retried")
+	}
+
+	// the next publishing must not be affected by the previous return
+	if err := p.Publish(context.Background(), newTestMessage("2")); err != nil {
+		t.Errorf("publish failed: %s", err)
+	}
+}
+
+func TestProducer_UnroutableHandler(t *testing.T) {
+	p := newTestProducer(t, transport.NewMemoryBroker())
+	defer p.Close()
+
+	var handled *eventbusclient.Message
+	p.OnUnroutable(func(ctx context.Context, message *eventbusclient.Message, err *UnroutableError) error {
+		handled = message
+		return nil
+	})
+
+	msg := newTestMessage("1")
+	msg.RoutingKey = "no_queue_bound"
+	if err := p.PublishRaw(context.Background(), msg); err != nil {
+		t.Errorf("expect handler result to be returned, got: %v", err)
+	}
+	if handled != msg {
+		t.Error("expect handler to receive the unroutable message")
+	}
+}
+
+func TestProducer_PublishBatch(t *testing.T) {
+	broker := transport.NewMemoryBroker()
+	p := newTestProducer(t, broker)
+	defer p.Close()
+
+	msgs := make([]*eventbusclient.Message, 50)
+	for i := range msgs {
+		msgs[i] = newTestMessage(fmt.Sprintf("%d", i))
+	}
+	msgs[10].RoutingKey = "no_queue_bound"
+
+	errs := p.PublishBatch(context.Background(), msgs)
+
+	if len(errs) != len(msgs) {
+		t.Fatalf("expect one error per message, got %d", len(errs))
+	}
+	for i, err := range errs {
+		if i == 10 {
+			if !errors.Is(err, ErrMessageUnroutable) {
+				t.Errorf("expect message 10 to be unroutable, got: %v", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("message %d: %s", i, err)
+		}
+	}
+	if broker.MessageCount(testQueue) != 49 {
+		t.Errorf("expect 49 messages in queue, got %d", broker.MessageCount(testQueue))
+	}
+}
+
+func TestProducer_PublishAsync(t *testing.T) {
+	p := newTestProducer(t, transport.NewMemoryBroker())
+	defer p.Close()
+
+	msg := newTestMessage("1")
+	select {
+	case result := <-p.PublishAsync(context.Background(), msg):
+		if result.Err != nil || result.Message != msg {
+			t.Errorf("wrong result: %+v", result)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no publish result")
+	}
+
+	invalid := newTestMessage("2")
+	invalid.Payload.Data = nil
+	if result := <-p.PublishAsync(context.Background(), invalid); result.Err == nil {
+		t.Error("expect validation error")
+	}
+}
+
+func returnOf(tag uint64) amqp.Return {
+	return amqp.Return{ReplyText: "NO_ROUTE", Headers: amqp.Table{eventbusclient.PublishTagHeader: int64(tag)}}
+}
+
+func TestConfirmTracker_MultipleAck(t *testing.T) {
+	confirms := make(chan amqp.Confirmation)
+	returns := make(chan amqp.Return)
+	tracker := newConfirmTracker(confirms, returns)
+
+	var results []<-chan error
+	for i := 0; i < 3; i++ {
+		_, result := tracker.reserve()
+		results = append(results, result)
+	}
+
+	returns <- returnOf(2)
+	// a return without tag is not guessed
+	returns <- amqp.Return{ReplyText: "NO_ROUTE"}
+	// a single confirm covering the three publishings
+	confirms <- amqp.Confirmation{DeliveryTag: 3, Ack: true}
+
+	if err := <-results[0]; err != nil {
+		t.Errorf("expect tag 1 to be acked, got: %v", err)
+	}
+	if err := <-results[1]; !errors.Is(err, ErrMessageUnroutable) {
+		t.Errorf("expect tag 2 to be unroutable, got: %v", err)
+	}
+	if err := <-results[2]; err != nil {
+		t.Errorf("expect tag 3 to be acked, got: %v", err)
+	}
+
+	_, result := tracker.reserve()
+	close(confirms)
+	if err := <-result; err != ErrMessageSendConfirmFailed {
+		t.Errorf("expect pending publishing to fail on close, got: %v", err)
+	}
+}
+
+func TestProducer_UnroutableWithoutMessageId(t *testing.T) {
+	broker := transport.NewMemoryBroker()
+	// both publishings on the same channel
+	p, err := NewProducerWithConfig(&eventbusclient.Config{
+		Transport:                broker,
+		Topology:                 &eventbusclient.Topology{Queues: []eventbusclient.Queue{{Name: testQueue, Durable: true}}},
+		PublisherChannelPoolSize: 1,
+	})
+	if err != nil {
+		t.Fatalf("create producer: %s", err)
+	}
+	defer p.Close()
+
+	routable := newTestMessage("")
+	unroutable := newTestMessage("")
+	unroutable.RoutingKey = "no_queue_bound"
+	errs := p.PublishBatch(context.Background(), []*eventbusclient.Message{routable, unroutable})
+
+	if errs[0] != nil {
+		t.Errorf("expect the routable message published, got: %v", errs[0])
+	}
+	if !errors.Is(errs[1], ErrMessageUnroutable) {
+		t.Errorf("expect the second message unroutable, got: %v", errs[1])
+	}
+}
+
+func TestProducer_ConcurrentPublish(t *testing.T) {
+	broker := transport.NewMemoryBroker()
+	p := newTestProducer(t, broker)
+	defer p.Close()
+
+	const publishers, perPublisher = 16, 25
+	var wg sync.WaitGroup
+	errs := make(chan error, publishers*perPublisher)
+	for i := 0; i < publishers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < perPublisher; j++ {
+				if err := p.Publish(context.Background(), newTestMessage(fmt.Sprintf("%d-%d", i, j))); err != nil {
+					errs <- err
+				}
+				// drop the connection while the other publishers are running
+				if i == 0 && j%10 == 5 {
+					broker.DropConnections()
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Errorf("publish failed: %s", err)
+	}
+
+	// a publishing whose confirm was lost with the connection is sent again, so it may be received twice
+	received := map[string]bool{}
+	conn, _ := broker.Dial("")
+	defer conn.Close()
+	ch, _ := conn.Channel()
+	deliveries, _ := ch.Consume(testQueue, "", true, false, false, false, nil)
+	for {
+		select {
+		case d := <-deliveries:
+			received[d.MessageId] = true
+			continue
+		case <-time.After(100 * time.Millisecond):
+		}
+		break
+	}
+	if len(received) != publishers*perPublisher {
+		t.Errorf("expect %d distinct messages, got %d", publishers*perPublisher, len(received))
+	}
+}
+
+func TestProducer_Compression(t *testing.T) {
+	broker := transport.NewMemoryBroker()
+	p, err := NewProducerWithConfig(&eventbusclient.Config{
+		Transport:            broker,
+		Compression:          eventbusclient.ContentEncodingGzip,
+		CompressionThreshold: 512,
+		Topology:             &eventbusclient.Topology{Queues: []eventbusclient.Queue{{Name: testQueue}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	small := newTestMessage("small")
+	if err := p.Publish(context.Background(), small); err != nil {
+		t.Fatal(err)
+	}
+	if d := consumeOne(t, broker); d.ContentEncoding != "" {
+		t.Errorf("expect small body sent uncompressed, got %s", d.ContentEncoding)
+	}
+
+	large := newTestMessage("large")
+	large.Payload.Data = map[string]interface{}{"items": strings.Repeat("package ", 1000)}
+	if err := p.Publish(context.Background(), large); err != nil {
+		t.Fatal(err)
+	}
+	d := consumeOne(t, broker)
+	if d.ContentEncoding != eventbusclient.ContentEncodingGzip || len(d.Body) >= 8000 {
+		t.Fatalf("expect large body compressed, got %q encoding and %d bytes", d.ContentEncoding, len(d.Body))
+	}
+	msg, err := helper.GetMessageFromDelivery(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Payload.Data.(map[string]interface{})["items"] != large.Payload.Data.(map[string]interface{})["items"] {
+		t.Error("expect decompressed payload")
+	}
+}
+
+func TestProducer_UnknownCompression(t *testing.T) {
+	_, err := NewProducerWithConfig(&eventbusclient.Config{Transport: transport.NewMemoryBroker(), Compression: "lz4"})
+	if _, ok := err.(*eventbusclient.UnsupportedContentEncodingError); !ok {
+		t.Errorf("expect unsupported content encoding error, got %v", err)
+	}
+}
+
+func TestProducer_ChannelRefreshWhilePublishing(t *testing.T) {
+	broker := transport.NewMemoryBroker()
+	p, err := NewProducerWithConfig(&eventbusclient.Config{
+		Transport:                broker,
+		Topology:                 &eventbusclient.Topology{Queues: []eventbusclient.Queue{{Name: testQueue, Durable: true}}},
+		PublisherChannelPoolSize: 2,
+		PublishMaxAttempts:       20,
+		PublishRetryInterval:     time.Millisecond,
+		PublishRetryMaxInterval:  time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("create producer: %s", err)
+	}
+	defer p.Close()
+
+	const publishers, perPublisher = 8, 20
+	var wg sync.WaitGroup
+	errs := make(chan error, publishers*perPublisher)
+	for i := 0; i < publishers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < perPublisher; j++ {
+				if err := p.Publish(context.Background(), newTestMessage(fmt.Sprintf("%d-%d", i, j))); err != nil {
+					errs <- err
+				}
+			}
+		}(i)
+	}
+	// the broker closes the channel of a publishing to a missing exchange, the pool reopens it
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for j := 0; j < 10; j++ {
+			msg := newTestMessage(fmt.Sprintf("missing-%d", j))
+			msg.Exchange = "missing_exchange"
+			_ = p.Publish(context.Background(), msg)
+		}
+	}()
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Errorf("publish failed: %s", err)
+	}
+}
+
+func TestProducer_CloseWhilePublishing(t *testing.T) {
+	broker := transport.NewMemoryBroker()
+	p := newTestProducer(t, broker)
+
+	var wg sync.WaitGroup
+	closed := make(chan struct{})
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; ; j++ {
+				// in flight publishings may succeed or fail, the ones started after Close fail
+				select {
+				case <-closed:
+					if err := p.Publish(context.Background(), newTestMessage(fmt.Sprintf("%d-last", i))); err == nil {
+						t.Error("expect publishing on a closed producer to fail")
+					}
+					return
+				default:
+				}
+				ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+				_ = p.Publish(ctx, newTestMessage(fmt.Sprintf("%d-%d", i, j)))
+				cancel()
+			}
+		}(i)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	var closers sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		closers.Add(1)
+		go func() {
+			defer closers.Done()
+			if err := p.Close(); err != nil {
+				t.Errorf("close failed: %s", err)
+			}
+		}()
+	}
+	closers.Wait()
+	close(closed)
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("publishers still blocked after close")
+	}
+}
+
+func TestConfirmTracker_InterleavedConfirmsAndReturns(t *testing.T) {
+	confirms := make(chan amqp.Confirmation)
+	returns := make(chan amqp.Return)
+	tracker := newConfirmTracker(confirms, returns)
+
+	const publishings = 200
+	sent := make(chan uint64)
+	// the broker side: every third publishing is returned, confirms sometimes cover several publishings
+	go func() {
+		for tag := range sent {
+			if tag%3 == 0 {
+				returns <- returnOf(tag)
+			}
+			if tag%4 == 0 || tag == publishings {
+				confirms <- amqp.Confirmation{DeliveryTag: tag, Ack: true}
+			}
+		}
+		close(confirms)
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < publishings; i++ {
+		tag, result := tracker.reserve()
+		wg.Add(1)
+		go func(tag uint64, result <-chan error) {
+			defer wg.Done()
+			err := (&pendingConfirm{tracker: tracker, tag: tag, result: result}).wait(context.Background())
+			if tag%3 == 0 && !errors.Is(err, ErrMessageUnroutable) {
+				t.Errorf("expect tag %d to be unroutable, got: %v", tag, err)
+			}
+			if tag%3 != 0 && err != nil {
+				t.Errorf("expect tag %d to be acked, got: %v", tag, err)
+			}
+		}(tag, result)
+		sent <- tag
+	}
+	close(sent)
+	wg.Wait()
+}
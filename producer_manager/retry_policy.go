@@ -0,0 +1,65 @@
+package producer_manager
+
+import (
+	"math/rand"
+	"time"
+
+	eventbusclient "github.com/best-expendables/eventbus-client"
+)
+
+const (
+	defaultMaxAttempts     = 5
+	defaultInitialInterval = 100 * time.Millisecond
+	defaultMaxInterval     = 5 * time.Second
+)
+
+// RetryPolicy how many times and how often a failed publishing is retried
+type RetryPolicy struct {
+	// MaxAttempts number of publishing attempts, including the first one
+	MaxAttempts int
+	// InitialInterval wait before the second attempt, doubled after every failed attempt
+	InitialInterval time.Duration
+	// MaxInterval upper bound of the wait between two attempts
+	MaxInterval time.Duration
+}
+
+// NewRetryPolicyFromConfig build the retry policy from config, zero values fall back to the defaults
+func NewRetryPolicyFromConfig(config *eventbusclient.Config) RetryPolicy {
+	policy := RetryPolicy{
+		MaxAttempts:     config.PublishMaxAttempts,
+		InitialInterval: config.PublishRetryInterval,
+		MaxInterval:     config.PublishRetryMaxInterval,
+	}
+	if policy.MaxAttempts <= 0 {
+		policy.MaxAttempts = defaultMaxAttempts
+	}
+	if policy.InitialInterval <= 0 {
+		policy.InitialInterval = defaultInitialInterval
+	}
+	if policy.MaxInterval <= 0 {
+		policy.MaxInterval = defaultMaxInterval
+	}
+	if policy.MaxInterval < policy.InitialInterval {
+		policy.MaxInterval = policy.InitialInterval
+	}
+
+	return policy
+}
+
+// Backoff wait before the given attempt, attempt 1 being the first retry.
+// The exponential delay is jittered between half and all of its value so retrying producers spread out
+func (r RetryPolicy) Backoff(attempt int) time.Duration {
+	delay := r.InitialInterval
+	for i := 1; i < attempt && delay < r.MaxInterval; i++ {
+		delay *= 2
+	}
+	if delay > r.MaxInterval {
+		delay = r.MaxInterval
+	}
+
+	half := int64(delay / 2)
+	if half <= 0 {
+		return delay
+	}
+	return time.Duration(half + rand.Int63n(half+1))
+}
@@ -0,0 +1,171 @@
+package schema
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	eventbusclient "github.com/best-expendables/eventbus-client"
+	"github.com/best-expendables/eventbus-client/consumer/consumer_middleware"
+	"github.com/best-expendables/eventbus-client/helper"
+	"github.com/best-expendables/eventbus-client/producer_manager"
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// ValidationError the payload data does not match the JSON Schema of its event
+type ValidationError struct {
+	EventName string
+	// Errors one description per violation, e.g. `packageId: String length must be greater than or equal to 1`
+	Errors []string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("payload of event %q does not match its schema: %s", e.EventName, strings.Join(e.Errors, "; "))
+}
+
+// UnsupportedPayloadError the payload of an event having a schema can not be validated, it is not JSON or still encrypted
+type UnsupportedPayloadError struct {
+	EventName string
+	Reason    string
+}
+
+func (e *UnsupportedPayloadError) Error() string {
+	return fmt.Sprintf("payload of event %q can not be validated against its schema: %s", e.EventName, e.Reason)
+}
+
+// Registry JSON Schemas of the payload data keyed by event name
+type Registry struct {
+	mu      sync.RWMutex
+	schemas map[string]*gojsonschema.Schema
+}
+
+func NewRegistry() *Registry {
+	return &Registry{schemas: map[string]*gojsonschema.Schema{}}
+}
+
+// AddString compile and register the schema of an event
+func (r *Registry) AddString(eventName, schema string) error {
+	return r.add(eventName, gojsonschema.NewStringLoader(schema))
+}
+
+// AddFile compile and register the schema file of an event
+func (r *Registry) AddFile(eventName, path string) error {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	return r.add(eventName, gojsonschema.NewBytesLoader(content))
+}
+
+// LoadDir register every `<eventName>.json` file of the directory
+func (r *Registry) LoadDir(dir string) error {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		eventName := strings.TrimSuffix(filepath.Base(path), ".json")
+		if err := r.AddFile(eventName, path); err != nil {
+			return fmt.Errorf("load schema %s: %s", path, err)
+		}
+	}
+
+	return nil
+}
+
+func (r *Registry) add(eventName string, loader gojsonschema.JSONLoader) error {
+	schema, err := gojsonschema.NewSchema(loader)
+	if err != nil {
+		return fmt.Errorf("compile schema of event %q: %s", eventName, err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.schemas[eventName] = schema
+
+	return nil
+}
+
+// Validate the payload data against the schema of the message event, events without schema are valid.
+// Only JSON payloads are validated, an *UnsupportedPayloadError is returned for other content types and encrypted payloads
+func (r *Registry) Validate(message *eventbusclient.Message) error {
+	r.mu.RLock()
+	schema, ok := r.schemas[message.Header.EventName]
+	r.mu.RUnlock()
+	if !ok {
+		return nil
+	}
+
+	if message.ContentType != "" && message.ContentType != eventbusclient.ContentTypeJSON {
+		return &UnsupportedPayloadError{EventName: message.Header.EventName, Reason: fmt.Sprintf("content type %s is not JSON", message.ContentType)}
+	}
+	if _, encrypted := message.Header.Extra[eventbusclient.EncryptionKeyIdHeader]; encrypted {
+		return &UnsupportedPayloadError{EventName: message.Header.EventName, Reason: "it is encrypted, validate it before encrypting and after decrypting"}
+	}
+	loader := gojsonschema.NewGoLoader(message.Payload.Data)
+	if encoded, ok := message.Payload.Data.(eventbusclient.EncodedData); ok {
+		loader = gojsonschema.NewBytesLoader(encoded)
+	}
+
+	result, err := schema.Validate(loader)
+	if err != nil {
+		return err
+	}
+	if result.Valid() {
+		return nil
+	}
+
+	validationErr := &ValidationError{EventName: message.Header.EventName}
+	for _, violation := range result.Errors() {
+		validationErr.Errors = append(validationErr.Errors, violation.String())
+	}
+
+	return validationErr
+}
+
+// PublishMiddleware refuse to publish messages whose payload does not match their schema
+func PublishMiddleware(registry *Registry) producer_manager.PublishFuncMiddleware {
+	return func(next producer_manager.PublishFunc) producer_manager.PublishFunc {
+		return func(ctx context.Context, message *eventbusclient.Message) error {
+			if err := registry.Validate(message); err != nil {
+				return err
+			}
+
+			return next(ctx, message)
+		}
+	}
+}
+
+// FailureHandler what happens to a consumed message whose payload does not match its schema
+type FailureHandler func(ctx context.Context, message *eventbusclient.Message, err error)
+
+// RejectInvalid reject the message with the validation error,
+// consumer_middleware.DeadLetter republishes it, the broker dead-letter exchange applies otherwise
+func RejectInvalid(ctx context.Context, message *eventbusclient.Message, err error) {
+	message.Error = err
+	message.Status = eventbusclient.MessageStatusReject
+}
+
+// AckInvalid log the validation error and drop the message
+func AckInvalid(ctx context.Context, message *eventbusclient.Message, err error) {
+	fields := helper.GetLogFieldFromMessage(message)
+	helper.LoggerFromCtx(ctx).WithFields(fields).Errorf("MessageInvalid: %s", err)
+	message.Status = eventbusclient.MessageStatusAck
+}
+
+// ConsumeMiddleware hand messages whose payload does not match their schema to onInvalid instead of the consumer
+func ConsumeMiddleware(registry *Registry, onInvalid FailureHandler) consumer_middleware.Middleware {
+	return func(next consumer_middleware.ConsumeFunc) consumer_middleware.ConsumeFunc {
+		return func(ctx context.Context, message *eventbusclient.Message) {
+			if err := registry.Validate(message); err != nil {
+				onInvalid(ctx, message, err)
+				return
+			}
+			next(ctx, message)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package schema
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	eventbusclient "github.com/best-expendables/eventbus-client"
+	"github.com/best-expendables/eventbus-client/codec"
+	"github.com/best-expendables/eventbus-client/producer_manager"
+	"github.com/best-expendables/eventbus-client/transport"
+)
+
+const testQueue = "package_creation"
+
+func newTestRegistry(t *testing.T) *Registry {
+	registry := NewRegistry()
+	if err := registry.LoadDir("testdata"); err != nil {
+		t.Fatal(err)
+	}
+	return registry
+}
+
+func newTestMessage(data map[string]interface{}) *eventbusclient.Message {
+	return &eventbusclient.Message{
+		Id:         "1",
+		RoutingKey: testQueue,
+		Header:     eventbusclient.Header{Timestamp: time.Now(), Publisher: "package", EventName: "package_creation"},
+		Payload:    eventbusclient.Payload{EntityId: "entity", Data: data},
+		Status:     eventbusclient.MessageStatusAck,
+	}
+}
+
+func TestRegistry_Validate(t *testing.T) {
+	registry := newTestRegistry(t)
+
+	if err := registry.Validate(newTestMessage(map[string]interface{}{"packageId": "P1", "weight": 3})); err != nil {
+		t.Errorf("expect valid payload, got %s", err)
+	}
+
+	err := registry.Validate(newTestMessage(map[string]interface{}{"packageId": "", "weight": -1}))
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("expect ValidationError, got %v", err)
+	}
+	if validationErr.EventName != "package_creation" || len(validationErr.Errors) != 2 {
+		t.Errorf("expect one error per violation, got %s", err)
+	}
+
+	unknown := newTestMessage(map[string]interface{}{})
+	unknown.Header.EventName = "package_update"
+	if err := registry.Validate(unknown); err != nil {
+		t.Errorf("expect event without schema to be valid, got %s", err)
+	}
+}
+
+func TestRegistry_ValidateNonJSON(t *testing.T) {
+	registry := newTestRegistry(t)
+
+	encoded := newTestMessage(nil)
+	encoded.Payload.Data = eventbusclient.EncodedData(`{"packageId": "", "weight": 3}`)
+	var validationErr *ValidationError
+	if err := registry.Validate(encoded); !errors.As(err, &validationErr) {
+		t.Errorf("expect encoded JSON data to be validated, got %v", err)
+	}
+
+	msgpack := newTestMessage(nil)
+	msgpack.ContentType = codec.Msgpack{}.ContentType()
+	msgpack.Payload.Data = eventbusclient.EncodedData{0x81}
+	var unsupported *UnsupportedPayloadError
+	if err := registry.Validate(msgpack); !errors.As(err, &unsupported) || unsupported.EventName != "package_creation" {
+		t.Errorf("expect UnsupportedPayloadError for a msgpack payload, got %v", err)
+	}
+	msgpack.Header.EventName = "package_update"
+	if err := registry.Validate(msgpack); err != nil {
+		t.Errorf("expect event without schema to be valid, got %s", err)
+	}
+
+	encrypted := newTestMessage(nil)
+	encrypted.Payload.Data = "ciphertext"
+	encrypted.Header.SetExtra(eventbusclient.EncryptionKeyIdHeader, "key-1")
+	if err := registry.Validate(encrypted); !errors.As(err, &unsupported) {
+		t.Errorf("expect UnsupportedPayloadError for an encrypted payload, got %v", err)
+	}
+}
+
+func TestRegistry_AddStringError(t *testing.T) {
+	if err := NewRegistry().AddString("package_creation", `{"type": 1}`); err == nil {
+		t.Error("expect invalid schema to be refused")
+	}
+}
+
+func TestPublishMiddleware(t *testing.T) {
+	broker := transport.NewMemoryBroker()
+	p, err := producer_manager.NewProducerWithConfig(&eventbusclient.Config{
+		Transport: broker,
+		Topology:  &eventbusclient.Topology{Queues: []eventbusclient.Queue{{Name: testQueue}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	p.Use(PublishMiddleware(newTestRegistry(t)))
+
+	err = p.Publish(context.Background(), newTestMessage(map[string]interface{}{"packageId": "P1"}))
+	if _, ok := err.(*ValidationError); !ok {
+		t.Errorf("expect ValidationError, got %v", err)
+	}
+	if err := p.Publish(context.Background(), newTestMessage(map[string]interface{}{"packageId": "P1", "weight": 1})); err != nil {
+		t.Errorf("publish failed: %s", err)
+	}
+	if broker.MessageCount(testQueue) != 1 {
+		t.Errorf("expect only the valid message to be published, got %d", broker.MessageCount(testQueue))
+	}
+}
+
+func TestConsumeMiddleware(t *testing.T) {
+	registry := newTestRegistry(t)
+	var consumed int
+	consume := func(ctx context.Context, message *eventbusclient.Message) {
+		consumed++
+	}
+
+	rejected := newTestMessage(map[string]interface{}{"packageId": "P1"})
+	ConsumeMiddleware(registry, RejectInvalid)(consume)(context.Background(), rejected)
+	if consumed != 0 || rejected.Status != eventbusclient.MessageStatusReject || rejected.Error == nil {
+		t.Errorf("expect invalid message to be rejected, got %+v", rejected)
+	}
+
+	dropped := newTestMessage(map[string]interface{}{"packageId": "P1"})
+	ConsumeMiddleware(registry, AckInvalid)(consume)(context.Background(), dropped)
+	if consumed != 0 || dropped.Status != eventbusclient.MessageStatusAck || dropped.Error != nil {
+		t.Errorf("expect invalid message to be acked, got %+v", dropped)
+	}
+
+	ConsumeMiddleware(registry, RejectInvalid)(consume)(context.Background(), newTestMessage(map[string]interface{}{"packageId": "P1", "weight": 1}))
+	if consumed != 1 {
+		t.Error("expect valid message to be consumed")
+	}
+}
@@ -0,0 +1,222 @@
+package signing
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"time"
+
+	eventbusclient "github.com/best-expendables/eventbus-client"
+	"github.com/best-expendables/eventbus-client/consumer/consumer_middleware"
+	"github.com/best-expendables/eventbus-client/helper"
+	"github.com/best-expendables/eventbus-client/producer_manager"
+)
+
+// SignatureHeader base64 HMAC-SHA256 of the message id, publisher, event name, timestamp, entity id and payload data
+const SignatureHeader = "x-signature"
+
+var (
+	ErrMissingSignature    = errors.New("message is not signed")
+	ErrInvalidSignature    = errors.New("message signature is invalid")
+	ErrOutsideReplayWindow = errors.New("message timestamp is outside the replay window")
+	ErrUnknownPublisherKey = errors.New("no signing key for the publisher")
+)
+
+// VerificationError the message is not signed by its publisher
+type VerificationError struct {
+	Publisher string
+	Err       error
+}
+
+func (e *VerificationError) Error() string {
+	return fmt.Sprintf("verify message from publisher %q: %s", e.Publisher, e.Err)
+}
+
+func (e *VerificationError) Unwrap() error {
+	return e.Err
+}
+
+// KeyFunc HMAC key of a publisher, ErrUnknownPublisherKey when it has none
+type KeyFunc func(publisher string) ([]byte, error)
+
+// StaticKeys keys by publisher name
+func StaticKeys(keys map[string][]byte) KeyFunc {
+	return func(publisher string) ([]byte, error) {
+		key, ok := keys[publisher]
+		if !ok {
+			return nil, ErrUnknownPublisherKey
+		}
+		return key, nil
+	}
+}
+
+type config struct {
+	replayWindow  time.Duration
+	allowUnsigned bool
+}
+
+// Option configure the verifying middleware
+type Option func(*config)
+
+// WithReplayWindow reject messages whose timestamp is further than window from now.
+// Retried messages keep their timestamp, the window must cover the retry delays
+func WithReplayWindow(window time.Duration) Option {
+	return func(c *config) {
+		c.replayWindow = window
+	}
+}
+
+// AllowUnsigned let messages without signature through, while publishers are rolling out signing
+func AllowUnsigned() Option {
+	return func(c *config) {
+		c.allowUnsigned = true
+	}
+}
+
+// PublishMiddleware sign messages with the publisher key. Add it before the middlewares changing the payload,
+// e.g. encryption, so it signs what is sent. Republished consumed messages, e.g. retries, keep their signature
+// unless their data was changed
+func PublishMiddleware(key []byte) producer_manager.PublishFuncMiddleware {
+	return func(next producer_manager.PublishFunc) producer_manager.PublishFunc {
+		return func(ctx context.Context, message *eventbusclient.Message) error {
+			data, sent, err := encodeData(message)
+			if err != nil {
+				return err
+			}
+
+			signed := *message
+			signed.Payload.Data = sent
+			signed.Header.Extra = map[string]interface{}{}
+			for key, value := range message.Header.Extra {
+				signed.Header.Extra[key] = value
+			}
+			_, hasSignature := message.Header.Extra[SignatureHeader]
+			if !hasSignature || !unchanged(message) {
+				signed.Header.SetExtra(SignatureHeader, base64.StdEncoding.EncodeToString(sign(key, message, data)))
+			}
+
+			return next(ctx, &signed)
+		}
+	}
+}
+
+// ConsumeMiddleware reject messages without a valid signature from the key of their publisher with a *VerificationError.
+// Use it first so forged messages are not consumed
+func ConsumeMiddleware(keys KeyFunc, opts ...Option) consumer_middleware.Middleware {
+	c := &config{}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return func(next consumer_middleware.ConsumeFunc) consumer_middleware.ConsumeFunc {
+		return func(ctx context.Context, message *eventbusclient.Message) {
+			if err := verify(keys, c, message); err != nil {
+				if err == ErrMissingSignature && c.allowUnsigned {
+					next(ctx, message)
+					return
+				}
+				helper.LoggerFromCtx(ctx).WithFields(helper.GetLogFieldFromMessage(message)).Errorf("MessageVerificationFailed: %s", err)
+				message.Error = &VerificationError{Publisher: message.Header.Publisher, Err: err}
+				message.Status = eventbusclient.MessageStatusReject
+				return
+			}
+			next(ctx, message)
+		}
+	}
+}
+
+func verify(keys KeyFunc, c *config, message *eventbusclient.Message) error {
+	value, ok := message.Header.Extra[SignatureHeader]
+	if !ok {
+		return ErrMissingSignature
+	}
+	signature, err := base64.StdEncoding.DecodeString(fmt.Sprintf("%v", value))
+	if err != nil {
+		return ErrInvalidSignature
+	}
+
+	key, err := keys(message.Header.Publisher)
+	if err != nil {
+		return err
+	}
+	data, _, err := encodeData(message)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal(signature, sign(key, message, data)) {
+		return ErrInvalidSignature
+	}
+
+	if c.replayWindow > 0 {
+		age := time.Since(message.Header.Timestamp)
+		if age > c.replayWindow || age < -c.replayWindow {
+			return ErrOutsideReplayWindow
+		}
+	}
+	return nil
+}
+
+// encodeData payload data as sent, and the data value making the body carry exactly these bytes
+func encodeData(message *eventbusclient.Message) ([]byte, interface{}, error) {
+	codec, err := eventbusclient.LookupCodec(message.ContentType)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var data []byte
+	if unchanged(message) {
+		data = message.Payload.RawData()
+	} else if encoded, ok := message.Payload.Data.(eventbusclient.EncodedData); ok && codec.ContentType() != eventbusclient.ContentTypeJSON {
+		data = encoded
+	}
+	if len(data) == 0 {
+		if data, err = codec.Marshal(message.Payload.Data); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if codec.ContentType() == eventbusclient.ContentTypeJSON {
+		return data, json.RawMessage(data), nil
+	}
+	return data, eventbusclient.EncodedData(data), nil
+}
+
+// unchanged the payload data is still the one received, so its raw bytes can be sent again
+func unchanged(message *eventbusclient.Message) bool {
+	raw := message.Payload.RawData()
+	if len(raw) == 0 {
+		return false
+	}
+	if encoded, ok := message.Payload.Data.(eventbusclient.EncodedData); ok {
+		return bytes.Equal(encoded, raw)
+	}
+
+	var received eventbusclient.Payload
+	if err := received.DecodeData(message.ContentType, raw); err != nil {
+		return false
+	}
+	return reflect.DeepEqual(received.Data, message.Payload.Data)
+}
+
+// sign the canonical form of the message, every field prefixed by its length so none can spill into the next
+func sign(key []byte, message *eventbusclient.Message, data []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	for _, field := range []string{
+		message.Id,
+		message.Header.Publisher,
+		message.Header.EventName,
+		strconv.FormatInt(message.Header.Timestamp.Unix(), 10),
+		message.Payload.EntityId,
+		string(data),
+	} {
+		fmt.Fprintf(mac, "%d:%s", len(field), field)
+	}
+	return mac.Sum(nil)
+}
@@ -0,0 +1,158 @@
+package signing
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	eventbusclient "github.com/best-expendables/eventbus-client"
+	"github.com/best-expendables/eventbus-client/helper"
+	"github.com/best-expendables/eventbus-client/producer_manager"
+	"github.com/best-expendables/eventbus-client/transport"
+	"github.com/streadway/amqp"
+)
+
+const testQueue = "package_creation"
+
+var keys = StaticKeys(map[string][]byte{
+	"package": []byte("package-key"),
+	"order":   []byte("order-key"),
+})
+
+func newTestMessage() *eventbusclient.Message {
+	return &eventbusclient.Message{
+		Id:         "1",
+		RoutingKey: testQueue,
+		Header: eventbusclient.Header{
+			Timestamp: time.Now(),
+			Publisher: "package",
+			EventName: "package_creation",
+		},
+		Payload: eventbusclient.Payload{EntityId: "entity", Data: map[string]interface{}{"weight": 10, "id": "P1"}},
+	}
+}
+
+// publish the message signed with key and return its delivery
+func publish(t *testing.T, key []byte, msg *eventbusclient.Message) amqp.Delivery {
+	broker := transport.NewMemoryBroker()
+	p, err := producer_manager.NewProducerWithConfig(&eventbusclient.Config{
+		Transport: broker,
+		Topology:  &eventbusclient.Topology{Queues: []eventbusclient.Queue{{Name: testQueue}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	p.Use(PublishMiddleware(key))
+	if err := p.Publish(context.Background(), msg); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, _ := broker.Dial("")
+	defer conn.Close()
+	ch, _ := conn.Channel()
+	deliveries, _ := ch.Consume(testQueue, "", true, false, false, false, nil)
+	select {
+	case d := <-deliveries:
+		return d
+	case <-time.After(time.Second):
+		t.Fatal("expect message in queue")
+	}
+	return amqp.Delivery{}
+}
+
+func consume(t *testing.T, d amqp.Delivery, opts ...Option) (*eventbusclient.Message, bool) {
+	msg, err := helper.GetMessageFromDelivery(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var consumed bool
+	ConsumeMiddleware(keys, opts...)(func(ctx context.Context, message *eventbusclient.Message) {
+		consumed = true
+	})(context.Background(), msg)
+	return msg, consumed
+}
+
+func TestSigning(t *testing.T) {
+	d := publish(t, []byte("package-key"), newTestMessage())
+	msg, consumed := consume(t, d, WithReplayWindow(time.Minute))
+	if !consumed || msg.Error != nil {
+		t.Fatalf("expect signed message consumed, got %v", msg.Error)
+	}
+
+	// retried by the consumer, the message keeps the signature of its publisher
+	retried := publish(t, []byte("order-key"), msg)
+	if msg, consumed := consume(t, retried); !consumed {
+		t.Errorf("expect republished message consumed, got %v", msg.Error)
+	}
+}
+
+func TestSigning_ChangedData(t *testing.T) {
+	msg, _ := consume(t, publish(t, []byte("package-key"), newTestMessage()))
+
+	// a consumer changing the data before republishing, it is sent and signed again
+	msg.Payload.Data.(map[string]interface{})["weight"] = 20
+	republished, consumed := consume(t, publish(t, []byte("package-key"), msg))
+	if !consumed {
+		t.Fatalf("expect republished message consumed, got %v", republished.Error)
+	}
+	if weight := republished.Payload.Data.(map[string]interface{})["weight"]; weight != float64(20) {
+		t.Errorf("expect the changed data sent, got weight %v", weight)
+	}
+}
+
+func TestSigning_Rejected(t *testing.T) {
+	assertRejected := func(name string, d amqp.Delivery, expected error, opts ...Option) {
+		msg, consumed := consume(t, d, opts...)
+		if consumed || msg.Status != eventbusclient.MessageStatusReject || !errors.Is(msg.Error, expected) {
+			t.Errorf("%s: expect rejected with %v, got %v", name, expected, msg.Error)
+		}
+	}
+
+	d := publish(t, []byte("package-key"), newTestMessage())
+	tampered := d
+	tampered.Body = bytes.Replace(d.Body, []byte("P1"), []byte("P2"), 1)
+	assertRejected("tampered body", tampered, ErrInvalidSignature)
+
+	tampered = d
+	tampered.Headers = amqp.Table{}
+	for key, value := range d.Headers {
+		tampered.Headers[key] = value
+	}
+	tampered.Headers["eventName"] = "package_deletion"
+	assertRejected("tampered header", tampered, ErrInvalidSignature)
+
+	forged := newTestMessage()
+	assertRejected("forged publisher", publish(t, []byte("order-key"), forged), ErrInvalidSignature)
+
+	unknown := newTestMessage()
+	unknown.Header.Publisher = "unknown"
+	assertRejected("unknown publisher", publish(t, []byte("order-key"), unknown), ErrUnknownPublisherKey)
+
+	old := newTestMessage()
+	old.Header.Timestamp = time.Now().Add(-time.Hour)
+	assertRejected("replayed", publish(t, []byte("package-key"), old), ErrOutsideReplayWindow, WithReplayWindow(time.Minute))
+}
+
+func TestSigning_Unsigned(t *testing.T) {
+	broker := transport.NewMemoryBroker()
+	conn, _ := broker.Dial("")
+	defer conn.Close()
+	ch, _ := conn.Channel()
+	_, _ = ch.QueueDeclare(testQueue, false, false, false, false, nil)
+	_ = ch.Publish("", testQueue, false, false, amqp.Publishing{
+		Headers: amqp.Table{"timestamp": time.Now().Unix(), "publisher": "package"},
+		Body:    []byte(`{"data":{}}`),
+	})
+	deliveries, _ := ch.Consume(testQueue, "", true, false, false, false, nil)
+	d := <-deliveries
+
+	if msg, consumed := consume(t, d); consumed || !errors.Is(msg.Error, ErrMissingSignature) {
+		t.Errorf("expect unsigned message rejected, got %v", msg.Error)
+	}
+	if _, consumed := consume(t, d, AllowUnsigned()); !consumed {
+		t.Error("expect unsigned message consumed when allowed")
+	}
+}
@@ -0,0 +1,212 @@
+package eventbusclient
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+const (
+	// DelayedSuffix suffix of the routing key and queue used to delay a retried message
+	DelayedSuffix = ".delayed"
+	// DeadLetterSuffix suffix of the queue where consumer_middleware.DeadLetter republishes failed messages
+	DeadLetterSuffix = ".dead"
+
+	argMessageTTL           = "x-message-ttl"
+	argDeadLetterExchange   = "x-dead-letter-exchange"
+	argDeadLetterRoutingKey = "x-dead-letter-routing-key"
+)
+
+type (
+	// Topology exchanges, queues and bindings to declare on the broker
+	Topology struct {
+		Exchanges []Exchange
+		Queues    []Queue
+		Bindings  []Binding
+	}
+
+	// Exchange exchange declaration
+	Exchange struct {
+		Name       string
+		Kind       string
+		Durable    bool
+		AutoDelete bool
+		Internal   bool
+		Args       amqp.Table
+	}
+
+	// Queue queue declaration
+	Queue struct {
+		Name       string
+		Durable    bool
+		AutoDelete bool
+		Exclusive  bool
+		Args       amqp.Table
+
+		// MessageTTL how long a message can stay in the queue, no limit when zero
+		MessageTTL time.Duration
+		// DeadLetterExchange exchange where rejected or expired messages are republished
+		DeadLetterExchange string
+		// DeadLetterRoutingKey routing key used when dead-lettering, the original one is kept when empty
+		DeadLetterRoutingKey string
+
+		// DelayedRetry declares the `<name>.delayed` queue used by consumer_middleware.RetryWithError
+		DelayedRetry *DelayedRetry
+		// DeadLetterQueue declares the `<name>.dead` queue used by consumer_middleware.DeadLetter
+		DeadLetterQueue bool
+	}
+
+	// DelayedRetry messages published to the delayed queue wait for TTL then go back to the origin exchange.
+	// When Exchange and RoutingKey are empty they are dead-lettered with the exchange and routing key of the first binding
+	// of the origin queue, or straight to the origin queue through the default exchange when it has no binding.
+	// Other queues bound with the same routing key receive the retried message too, set RoutingKey to the origin queue
+	// name to deliver it to that queue only
+	DelayedRetry struct {
+		TTL time.Duration
+		// Exchange where expired messages are dead-lettered, the default exchange when empty and RoutingKey is set
+		Exchange string
+		// RoutingKey used when dead-lettering
+		RoutingKey string
+	}
+
+	// Binding binds a queue to an exchange
+	Binding struct {
+		Queue      string
+		Exchange   string
+		RoutingKey string
+		Args       amqp.Table
+	}
+
+	// TopologyDeclarer the channel methods needed to declare a topology, *amqp.Channel implements it
+	TopologyDeclarer interface {
+		ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error
+		QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error)
+		QueueBind(name, key, exchange string, noWait bool, args amqp.Table) error
+	}
+)
+
+// DelayedName name of the delayed queue or routing key for the given one
+func DelayedName(name string) string {
+	return name + DelayedSuffix
+}
+
+// DeadLetterName name of the dead letter queue for the given one
+func DeadLetterName(name string) string {
+	return name + DeadLetterSuffix
+}
+
+// Declare exchanges, queues and bindings. Declarations are idempotent so it is safe to call on every (re)connect
+func (t *Topology) Declare(ch TopologyDeclarer) error {
+	if t == nil {
+		return nil
+	}
+
+	for _, exchange := range t.Exchanges {
+		kind := exchange.Kind
+		if kind == "" {
+			kind = amqp.ExchangeDirect
+		}
+		err := ch.ExchangeDeclare(exchange.Name, kind, exchange.Durable, exchange.AutoDelete, exchange.Internal, false, exchange.Args)
+		if err != nil {
+			return fmt.Errorf("declare exchange %s: %s", exchange.Name, err)
+		}
+	}
+
+	for _, queue := range t.Queues {
+		if _, err := ch.QueueDeclare(queue.Name, queue.Durable, queue.AutoDelete, queue.Exclusive, false, queue.arguments()); err != nil {
+			return fmt.Errorf("declare queue %s: %s", queue.Name, err)
+		}
+		if queue.DeadLetterQueue {
+			dead := DeadLetterName(queue.Name)
+			if _, err := ch.QueueDeclare(dead, queue.Durable, queue.AutoDelete, false, false, nil); err != nil {
+				return fmt.Errorf("declare queue %s: %s", dead, err)
+			}
+		}
+		if queue.DelayedRetry == nil {
+			continue
+		}
+		delayed := t.delayedQueue(queue)
+		if _, err := ch.QueueDeclare(delayed.Name, delayed.Durable, delayed.AutoDelete, delayed.Exclusive, false, delayed.arguments()); err != nil {
+			return fmt.Errorf("declare queue %s: %s", delayed.Name, err)
+		}
+	}
+
+	for _, binding := range t.allBindings() {
+		if err := ch.QueueBind(binding.Queue, binding.RoutingKey, binding.Exchange, false, binding.Args); err != nil {
+			return fmt.Errorf("bind queue %s to exchange %s with key %s: %s", binding.Queue, binding.Exchange, binding.RoutingKey, err)
+		}
+	}
+
+	return nil
+}
+
+// allBindings configured bindings plus the ones routing `<routingKey>.delayed` to the delayed queues
+func (t *Topology) allBindings() []Binding {
+	delayedQueues := map[string]bool{}
+	for _, queue := range t.Queues {
+		if queue.DelayedRetry != nil {
+			delayedQueues[queue.Name] = true
+		}
+	}
+
+	bindings := make([]Binding, 0, len(t.Bindings))
+	bindings = append(bindings, t.Bindings...)
+	for _, binding := range t.Bindings {
+		if !delayedQueues[binding.Queue] || binding.Exchange == "" {
+			continue
+		}
+		bindings = append(bindings, Binding{
+			Queue:      DelayedName(binding.Queue),
+			Exchange:   binding.Exchange,
+			RoutingKey: DelayedName(binding.RoutingKey),
+			Args:       binding.Args,
+		})
+	}
+
+	return bindings
+}
+
+func (t *Topology) delayedQueue(q Queue) Queue {
+	exchange, routingKey := q.DelayedRetry.Exchange, q.DelayedRetry.RoutingKey
+	if exchange == "" && routingKey == "" {
+		routingKey = q.Name
+		for _, binding := range t.Bindings {
+			if binding.Queue == q.Name && binding.Exchange != "" {
+				exchange, routingKey = binding.Exchange, binding.RoutingKey
+				break
+			}
+		}
+	}
+
+	return Queue{
+		Name:                 DelayedName(q.Name),
+		Durable:              q.Durable,
+		AutoDelete:           q.AutoDelete,
+		MessageTTL:           q.DelayedRetry.TTL,
+		DeadLetterExchange:   exchange,
+		DeadLetterRoutingKey: routingKey,
+	}
+}
+
+func (q Queue) arguments() amqp.Table {
+	args := amqp.Table{}
+	for key, value := range q.Args {
+		args[key] = value
+	}
+	if q.MessageTTL > 0 {
+		args[argMessageTTL] = int64(q.MessageTTL / time.Millisecond)
+	}
+	// the default exchange is a valid dead-letter exchange, so it is set whenever a routing key is given
+	if q.DeadLetterExchange != "" || q.DeadLetterRoutingKey != "" {
+		args[argDeadLetterExchange] = q.DeadLetterExchange
+	}
+	if q.DeadLetterRoutingKey != "" {
+		args[argDeadLetterRoutingKey] = q.DeadLetterRoutingKey
+	}
+	if len(args) == 0 {
+		return nil
+	}
+
+	return args
+}
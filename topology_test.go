@@ -0,0 +1,166 @@
+package eventbusclient
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+type declaredQueue struct {
+	name string
+	args amqp.Table
+}
+
+type recordingDeclarer struct {
+	exchanges []string
+	queues    []declaredQueue
+	bindings  []Binding
+	queueErr  error
+}
+
+func (r *recordingDeclarer) ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error {
+	r.exchanges = append(r.exchanges, name+":"+kind)
+	return nil
+}
+
+func (r *recordingDeclarer) QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error) {
+	if r.queueErr != nil {
+		return amqp.Queue{}, r.queueErr
+	}
+	r.queues = append(r.queues, declaredQueue{name: name, args: args})
+	return amqp.Queue{Name: name}, nil
+}
+
+func (r *recordingDeclarer) QueueBind(name, key, exchange string, noWait bool, args amqp.Table) error {
+	r.bindings = append(r.bindings, Binding{Queue: name, Exchange: exchange, RoutingKey: key})
+	return nil
+}
+
+func TestTopology_Declare(t *testing.T) {
+	topology := &Topology{
+		Exchanges: []Exchange{{Name: "package", Kind: amqp.ExchangeTopic, Durable: true}},
+		Queues: []Queue{
+			{Name: "package_creation", Durable: true, DelayedRetry: &DelayedRetry{TTL: 5 * time.Second}},
+			{Name: "package_update", Durable: true},
+		},
+		Bindings: []Binding{
+			{Queue: "package_creation", Exchange: "package", RoutingKey: "package.created"},
+			{Queue: "package_update", Exchange: "package", RoutingKey: "package.updated"},
+		},
+	}
+
+	declarer := &recordingDeclarer{}
+	if err := topology.Declare(declarer); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if len(declarer.exchanges) != 1 || declarer.exchanges[0] != "package:topic" {
+		t.Errorf("wrong exchanges declared: %v", declarer.exchanges)
+	}
+	if len(declarer.queues) != 3 {
+		t.Fatalf("expect 3 queues declared, got: %v", declarer.queues)
+	}
+
+	delayed := declarer.queues[1]
+	if delayed.name != "package_creation.delayed" {
+		t.Errorf("expect delayed queue to be declared after its origin queue, got: %s", delayed.name)
+	}
+	if delayed.args[argMessageTTL] != int64(5000) {
+		t.Errorf("wrong ttl: %v", delayed.args[argMessageTTL])
+	}
+	if delayed.args[argDeadLetterExchange] != "package" || delayed.args[argDeadLetterRoutingKey] != "package.created" {
+		t.Errorf("delayed queue must dead-letter back to the origin exchange, got: %v", delayed.args)
+	}
+	if declarer.queues[0].args != nil {
+		t.Errorf("expect no arguments on origin queue, got: %v", declarer.queues[0].args)
+	}
+
+	expectBindings := []Binding{
+		{Queue: "package_creation", Exchange: "package", RoutingKey: "package.created"},
+		{Queue: "package_update", Exchange: "package", RoutingKey: "package.updated"},
+		{Queue: "package_creation.delayed", Exchange: "package", RoutingKey: "package.created.delayed"},
+	}
+	if len(declarer.bindings) != len(expectBindings) {
+		t.Fatalf("wrong bindings: %v", declarer.bindings)
+	}
+	for i, binding := range expectBindings {
+		if declarer.bindings[i].Queue != binding.Queue || declarer.bindings[i].RoutingKey != binding.RoutingKey {
+			t.Errorf("wrong binding %d, got: %v - expect: %v", i, declarer.bindings[i], binding)
+		}
+	}
+}
+
+func TestTopology_DeclareDelayedToOriginExchange(t *testing.T) {
+	topology := &Topology{
+		Queues: []Queue{{
+			Name:         "package_creation",
+			DelayedRetry: &DelayedRetry{TTL: time.Second, Exchange: "package", RoutingKey: "package.created"},
+		}},
+	}
+
+	declarer := &recordingDeclarer{}
+	if err := topology.Declare(declarer); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	args := declarer.queues[1].args
+	if args[argDeadLetterExchange] != "package" || args[argDeadLetterRoutingKey] != "package.created" {
+		t.Errorf("wrong dead-letter arguments: %v", args)
+	}
+}
+
+func TestTopology_DeclareDelayedToOriginQueue(t *testing.T) {
+	tests := map[string]*Topology{
+		"without binding": {
+			Queues: []Queue{{Name: "package_creation", DelayedRetry: &DelayedRetry{TTL: time.Second}}},
+		},
+		"with routing key": {
+			Queues:   []Queue{{Name: "package_creation", DelayedRetry: &DelayedRetry{TTL: time.Second, RoutingKey: "package_creation"}}},
+			Bindings: []Binding{{Queue: "package_creation", Exchange: "package", RoutingKey: "package.created"}},
+		},
+	}
+	for name, topology := range tests {
+		t.Run(name, func(t *testing.T) {
+			declarer := &recordingDeclarer{}
+			if err := topology.Declare(declarer); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			args := declarer.queues[1].args
+			if args[argDeadLetterExchange] != "" || args[argDeadLetterRoutingKey] != "package_creation" {
+				t.Errorf("expect the default exchange to the origin queue, got: %v", args)
+			}
+		})
+	}
+}
+
+func TestTopology_DeclareError(t *testing.T) {
+	topology := &Topology{Queues: []Queue{{Name: "package_creation"}}}
+
+	err := topology.Declare(&recordingDeclarer{queueErr: errors.New("PRECONDITION_FAILED")})
+	if err == nil {
+		t.Error("expect error to be returned")
+	}
+}
+
+func TestTopology_DeclareNil(t *testing.T) {
+	var topology *Topology
+	if err := topology.Declare(&recordingDeclarer{}); err != nil {
+		t.Errorf("expect nil topology to declare nothing, got: %s", err)
+	}
+}
+
+func TestTopology_DeclareDeadLetterQueue(t *testing.T) {
+	topology := &Topology{Queues: []Queue{{Name: "package_creation", Durable: true, DeadLetterQueue: true}}}
+
+	declarer := &recordingDeclarer{}
+	if err := topology.Declare(declarer); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if len(declarer.queues) != 2 || declarer.queues[1].name != "package_creation.dead" {
+		t.Errorf("expect dead letter queue to be declared, got: %v", declarer.queues)
+	}
+}
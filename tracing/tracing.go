@@ -0,0 +1,166 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+
+	eventbusclient "github.com/best-expendables/eventbus-client"
+	"github.com/best-expendables/eventbus-client/consumer/consumer_middleware"
+	"github.com/best-expendables/eventbus-client/producer_manager"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/best-expendables/eventbus-client/tracing"
+
+// Span attributes specific to the event bus
+const (
+	EventNameKey = attribute.Key("eventbus.event_name")
+	QueueKey     = attribute.Key("eventbus.queue")
+	OutcomeKey   = attribute.Key("eventbus.outcome")
+)
+
+type config struct {
+	tracerProvider trace.TracerProvider
+	propagator     propagation.TextMapPropagator
+}
+
+// Option configure the tracing middlewares
+type Option func(*config)
+
+// WithTracerProvider tracer provider creating the spans, the global one by default
+func WithTracerProvider(provider trace.TracerProvider) Option {
+	return func(c *config) {
+		c.tracerProvider = provider
+	}
+}
+
+// WithPropagator propagator writing the span context in the headers, W3C trace context and baggage by default
+func WithPropagator(propagator propagation.TextMapPropagator) Option {
+	return func(c *config) {
+		c.propagator = propagator
+	}
+}
+
+func newConfig(opts []Option) *config {
+	c := &config{
+		tracerProvider: otel.GetTracerProvider(),
+		propagator:     propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+// PublishMiddleware start a producer span and inject its context in the message headers
+func PublishMiddleware(opts ...Option) producer_manager.PublishFuncMiddleware {
+	c := newConfig(opts)
+	tracer := c.tracerProvider.Tracer(instrumentationName)
+
+	return func(next producer_manager.PublishFunc) producer_manager.PublishFunc {
+		return func(ctx context.Context, message *eventbusclient.Message) error {
+			ctx, span := tracer.Start(ctx, fmt.Sprintf("%s send", destination(message)),
+				trace.WithSpanKind(trace.SpanKindProducer),
+				trace.WithAttributes(messageAttributes(message)...),
+			)
+			defer span.End()
+
+			c.propagator.Inject(ctx, headerCarrier{header: &message.Header})
+
+			err := next(ctx, message)
+			if err != nil {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+				span.SetAttributes(OutcomeKey.String("failed"))
+				return err
+			}
+			span.SetAttributes(OutcomeKey.String("published"))
+
+			return nil
+		}
+	}
+}
+
+// ConsumeMiddleware extract the producer span context from the message headers and start a consumer span,
+// child of and linked to the producer span. The outcome attribute is the final message status
+func ConsumeMiddleware(opts ...Option) consumer_middleware.Middleware {
+	c := newConfig(opts)
+	tracer := c.tracerProvider.Tracer(instrumentationName)
+
+	return func(next consumer_middleware.ConsumeFunc) consumer_middleware.ConsumeFunc {
+		return func(ctx context.Context, message *eventbusclient.Message) {
+			ctx = c.propagator.Extract(ctx, headerCarrier{header: &message.Header})
+
+			startOpts := []trace.SpanStartOption{
+				trace.WithSpanKind(trace.SpanKindConsumer),
+				trace.WithAttributes(messageAttributes(message)...),
+				trace.WithAttributes(semconv.MessagingOperationProcess, QueueKey.String(message.Queue)),
+			}
+			if producer := trace.SpanContextFromContext(ctx); producer.IsValid() {
+				startOpts = append(startOpts, trace.WithLinks(trace.Link{SpanContext: producer}))
+			}
+			ctx, span := tracer.Start(ctx, fmt.Sprintf("%s process", message.Queue), startOpts...)
+			defer func() {
+				if message.Error != nil {
+					span.RecordError(message.Error)
+					span.SetStatus(codes.Error, message.Error.Error())
+				}
+				span.SetAttributes(OutcomeKey.String(message.Status))
+				span.End()
+			}()
+
+			next(ctx, message)
+		}
+	}
+}
+
+func destination(message *eventbusclient.Message) string {
+	if message.Exchange == "" {
+		return "(default)"
+	}
+	return message.Exchange
+}
+
+func messageAttributes(message *eventbusclient.Message) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		semconv.MessagingSystemKey.String("rabbitmq"),
+		semconv.MessagingDestinationKey.String(message.Exchange),
+		semconv.MessagingRabbitmqRoutingKeyKey.String(message.RoutingKey),
+		semconv.MessagingMessageIDKey.String(message.Id),
+		EventNameKey.String(message.Header.EventName),
+	}
+}
+
+// headerCarrier read and write the propagation fields as extra message headers
+type headerCarrier struct {
+	header *eventbusclient.Header
+}
+
+func (c headerCarrier) Get(key string) string {
+	switch value := c.header.Extra[key].(type) {
+	case string:
+		return value
+	case []byte:
+		return string(value)
+	default:
+		return ""
+	}
+}
+
+func (c headerCarrier) Set(key, value string) {
+	c.header.SetExtra(key, value)
+}
+
+func (c headerCarrier) Keys() []string {
+	keys := make([]string, 0, len(c.header.Extra))
+	for key := range c.header.Extra {
+		keys = append(keys, key)
+	}
+	return keys
+}
@@ -0,0 +1,138 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	eventbusclient "github.com/best-expendables/eventbus-client"
+	"github.com/best-expendables/eventbus-client/helper"
+	"github.com/best-expendables/eventbus-client/producer_manager"
+	"github.com/best-expendables/eventbus-client/transport"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/baggage"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const testQueue = "package_creation"
+
+func newTestMessage() *eventbusclient.Message {
+	return &eventbusclient.Message{
+		Id:         "1",
+		RoutingKey: testQueue,
+		Header:     eventbusclient.Header{Timestamp: time.Now(), Publisher: "package", EventName: "package_creation"},
+		Payload:    eventbusclient.Payload{EntityId: "entity", Data: map[string]interface{}{"id": "1"}},
+	}
+}
+
+func attributeValue(span tracetest.SpanStub, key attribute.Key) string {
+	for _, kv := range span.Attributes {
+		if kv.Key == key {
+			return kv.Value.Emit()
+		}
+	}
+	return ""
+}
+
+func TestTracing_PropagatesThroughBroker(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+	broker := transport.NewMemoryBroker()
+	p, err := producer_manager.NewProducerWithConfig(&eventbusclient.Config{
+		Transport: broker,
+		Topology:  &eventbusclient.Topology{Queues: []eventbusclient.Queue{{Name: testQueue}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	p.Use(PublishMiddleware(WithTracerProvider(provider)))
+
+	member, _ := baggage.NewMember("tenant", "vn")
+	bag, _ := baggage.New(member)
+	if err := p.Publish(baggage.ContextWithBaggage(context.Background(), bag), newTestMessage()); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, _ := broker.Dial("")
+	defer conn.Close()
+	ch, _ := conn.Channel()
+	deliveries, _ := ch.Consume(testQueue, "", true, false, false, false, nil)
+	var consumed *eventbusclient.Message
+	select {
+	case d := <-deliveries:
+		if consumed, err = helper.GetMessageFromDelivery(d); err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no message published")
+	}
+	consumed.Queue = testQueue
+
+	var consumerBaggage string
+	ConsumeMiddleware(WithTracerProvider(provider))(func(ctx context.Context, message *eventbusclient.Message) {
+		consumerBaggage = baggage.FromContext(ctx).Member("tenant").Value()
+		message.Error = errors.New("failed")
+		message.Status = eventbusclient.MessageStatusReject
+	})(context.Background(), consumed)
+
+	spans := exporter.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("expect producer and consumer spans, got %d", len(spans))
+	}
+	producer, consumer := spans[0], spans[1]
+
+	if producer.SpanKind != trace.SpanKindProducer || consumer.SpanKind != trace.SpanKindConsumer {
+		t.Errorf("wrong span kinds: %s, %s", producer.SpanKind, consumer.SpanKind)
+	}
+	if consumer.Parent.SpanID() != producer.SpanContext.SpanID() || consumer.SpanContext.TraceID() != producer.SpanContext.TraceID() {
+		t.Error("expect consumer span to be a child of the producer span")
+	}
+	if len(consumer.Links) != 1 || consumer.Links[0].SpanContext.SpanID() != producer.SpanContext.SpanID() {
+		t.Error("expect consumer span to be linked to the producer span")
+	}
+	if consumerBaggage != "vn" {
+		t.Errorf("expect baggage to be propagated, got %q", consumerBaggage)
+	}
+
+	for key, expected := range map[attribute.Key]string{
+		"messaging.rabbitmq.routing_key": testQueue,
+		EventNameKey:                     "package_creation",
+		OutcomeKey:                       "published",
+	} {
+		if got := attributeValue(producer, key); got != expected {
+			t.Errorf("producer attribute %s: expect %q, got %q", key, expected, got)
+		}
+	}
+	for key, expected := range map[attribute.Key]string{
+		QueueKey:     testQueue,
+		EventNameKey: "package_creation",
+		OutcomeKey:   eventbusclient.MessageStatusReject,
+	} {
+		if got := attributeValue(consumer, key); got != expected {
+			t.Errorf("consumer attribute %s: expect %q, got %q", key, expected, got)
+		}
+	}
+	if consumer.Status.Code != codes.Error {
+		t.Error("expect consumer span status to be error")
+	}
+}
+
+func TestConsumeMiddleware_WithoutTraceContext(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+	message := newTestMessage()
+	message.Status = eventbusclient.MessageStatusAck
+	ConsumeMiddleware(WithTracerProvider(provider))(func(ctx context.Context, message *eventbusclient.Message) {})(context.Background(), message)
+
+	spans := exporter.GetSpans()
+	if len(spans) != 1 || spans[0].Parent.IsValid() || len(spans[0].Links) != 0 {
+		t.Errorf("expect a root consumer span, got %+v", spans)
+	}
+}
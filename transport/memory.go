@@ -0,0 +1,991 @@
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+var (
+	// ErrUnknownDeliveryTag the delivery tag was already acked or never delivered on this channel
+	ErrUnknownDeliveryTag = errors.New("unknown delivery tag")
+
+	// ErrConnectionForced error sent to NotifyClose receivers when MemoryBroker.DropConnections is called
+	ErrConnectionForced = &amqp.Error{
+		Code:    amqp.ConnectionForced,
+		Reason:  "CONNECTION_FORCED - broker forced connection closure",
+		Server:  true,
+		Recover: true,
+	}
+)
+
+// MemoryBroker in-memory Transport for tests. It supports the default, direct, fanout and topic exchanges,
+// publisher confirms, mandatory returns, prefetch, redelivery of unacked messages,
+// message TTL and dead-lettering. Nothing is persisted
+type MemoryBroker struct {
+	mu          sync.Mutex
+	exchanges   map[string]string
+	bindings    []memoryBinding
+	queues      map[string]*memoryQueue
+	connections map[*memoryConnection]bool
+	dialErr     error
+	sequence    uint64
+}
+
+type memoryBinding struct {
+	queue      string
+	exchange   string
+	routingKey string
+}
+
+type memoryMessage struct {
+	exchange    string
+	routingKey  string
+	publishing  amqp.Publishing
+	redelivered bool
+}
+
+type memoryQueue struct {
+	name                 string
+	ttl                  time.Duration
+	deadLetterExchange   *string
+	deadLetterRoutingKey string
+	ready                []*memoryMessage
+	consumers            []*memoryConsumer
+	next                 int
+}
+
+// NewMemoryBroker create an empty in-memory broker
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{
+		exchanges: map[string]string{
+			amqp.ExchangeDirect: amqp.ExchangeDirect,
+			amqp.ExchangeFanout: amqp.ExchangeFanout,
+			amqp.ExchangeTopic:  amqp.ExchangeTopic,
+		},
+		queues:      map[string]*memoryQueue{},
+		connections: map[*memoryConnection]bool{},
+	}
+}
+
+// Dial connect to the broker, the url is ignored
+func (b *MemoryBroker) Dial(_ string) (Connection, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.dialErr != nil {
+		return nil, b.dialErr
+	}
+	conn := &memoryConnection{broker: b, channels: map[*memoryChannel]bool{}}
+	b.connections[conn] = true
+
+	return conn, nil
+}
+
+// SetDialError make every following Dial fail with err until it is called with nil, simulating a broker outage
+func (b *MemoryBroker) SetDialError(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.dialErr = err
+}
+
+// DropConnections close every open connection as if the broker went away. Unacked messages are requeued
+func (b *MemoryBroker) DropConnections() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for conn := range b.connections {
+		b.closeConnection(conn, ErrConnectionForced)
+	}
+}
+
+// MessageCount number of messages ready to be delivered from the queue
+func (b *MemoryBroker) MessageCount(queue string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if q, ok := b.queues[queue]; ok {
+		return len(q.ready)
+	}
+	return 0
+}
+
+// UnackedCount number of messages delivered from the queue and not acked yet
+func (b *MemoryBroker) UnackedCount(queue string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	count := 0
+	for conn := range b.connections {
+		for ch := range conn.channels {
+			for _, unacked := range ch.unacked {
+				if unacked.queue.name == queue {
+					count++
+				}
+			}
+		}
+	}
+	return count
+}
+
+// ConsumerCount number of consumers subscribed to the queue
+func (b *MemoryBroker) ConsumerCount(queue string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if q, ok := b.queues[queue]; ok {
+		return len(q.consumers)
+	}
+	return 0
+}
+
+func (b *MemoryBroker) closeConnection(conn *memoryConnection, err *amqp.Error) {
+	if conn.closed {
+		return
+	}
+	conn.closed = true
+	delete(b.connections, conn)
+	for ch := range conn.channels {
+		b.closeChannel(ch, err)
+	}
+	notifyClose(conn.closeReceivers, err)
+	conn.closeReceivers = nil
+}
+
+func (b *MemoryBroker) closeChannel(ch *memoryChannel, err *amqp.Error) {
+	if ch.closed {
+		return
+	}
+	ch.closed = true
+	delete(ch.conn.channels, ch)
+
+	for _, consumer := range ch.consumers {
+		b.removeConsumer(consumer)
+	}
+	ch.consumers = map[string]*memoryConsumer{}
+
+	// requeued from the highest tag so the queue keeps the original order
+	touched := map[*memoryQueue]bool{}
+	for tag := ch.deliveryTag; tag >= 1; tag-- {
+		unacked, ok := ch.unacked[tag]
+		if !ok {
+			continue
+		}
+		delete(ch.unacked, tag)
+		b.requeue(unacked.queue, unacked.message)
+		touched[unacked.queue] = true
+	}
+	for q := range touched {
+		b.dispatch(q)
+	}
+
+	confirms, returns, closeReceivers := ch.confirms, ch.returns, ch.closeReceivers
+	ch.events.push(func() {
+		for _, c := range confirms {
+			close(c)
+		}
+		for _, r := range returns {
+			close(r)
+		}
+	})
+	ch.events.stop()
+	notifyClose(closeReceivers, err)
+	ch.confirms, ch.returns, ch.closeReceivers = nil, nil, nil
+}
+
+func notifyClose(receivers []chan *amqp.Error, err *amqp.Error) {
+	if len(receivers) == 0 {
+		return
+	}
+	go func() {
+		for _, receiver := range receivers {
+			if err != nil {
+				receiver <- err
+			}
+			close(receiver)
+		}
+	}()
+}
+
+// route deliver the message to every queue bound to the exchange with a matching routing key
+func (b *MemoryBroker) route(exchange, routingKey string, publishing amqp.Publishing) int {
+	var queues []*memoryQueue
+	if exchange == "" {
+		if q, ok := b.queues[routingKey]; ok {
+			queues = append(queues, q)
+		}
+	} else {
+		kind := b.exchanges[exchange]
+		seen := map[string]bool{}
+		for _, binding := range b.bindings {
+			if binding.exchange != exchange || seen[binding.queue] || !matchRoutingKey(kind, binding.routingKey, routingKey) {
+				continue
+			}
+			if q, ok := b.queues[binding.queue]; ok {
+				seen[binding.queue] = true
+				queues = append(queues, q)
+			}
+		}
+	}
+
+	for _, q := range queues {
+		b.enqueue(q, &memoryMessage{
+			exchange:   exchange,
+			routingKey: routingKey,
+			publishing: copyPublishing(publishing),
+		})
+	}
+
+	return len(queues)
+}
+
+func (b *MemoryBroker) enqueue(q *memoryQueue, msg *memoryMessage) {
+	q.ready = append(q.ready, msg)
+	if q.ttl > 0 {
+		time.AfterFunc(q.ttl, func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			if q.remove(msg) {
+				b.deadLetter(q, msg, "expired")
+			}
+		})
+	}
+	b.dispatch(q)
+}
+
+func (b *MemoryBroker) requeue(q *memoryQueue, msg *memoryMessage) {
+	msg.redelivered = true
+	q.ready = append([]*memoryMessage{msg}, q.ready...)
+}
+
+func (b *MemoryBroker) deadLetter(q *memoryQueue, msg *memoryMessage, reason string) {
+	if q.deadLetterExchange == nil {
+		return
+	}
+	routingKey := q.deadLetterRoutingKey
+	if routingKey == "" {
+		routingKey = msg.routingKey
+	}
+
+	publishing := copyPublishing(msg.publishing)
+	if _, ok := publishing.Headers["x-first-death-queue"]; !ok {
+		publishing.Headers["x-first-death-queue"] = q.name
+		publishing.Headers["x-first-death-reason"] = reason
+		publishing.Headers["x-first-death-exchange"] = msg.exchange
+	}
+	publishing.Headers["x-death"] = addDeath(publishing.Headers["x-death"], q.name, reason, msg.exchange, msg.routingKey)
+	b.route(*q.deadLetterExchange, routingKey, publishing)
+}
+
+// addDeath count the dead-lettering in the x-death header as RabbitMQ does, one entry per queue and reason, latest first
+func addDeath(header interface{}, queue, reason, exchange, routingKey string) []interface{} {
+	deaths, _ := header.([]interface{})
+	for i, death := range deaths {
+		entry, ok := death.(amqp.Table)
+		if !ok || entry["queue"] != queue || entry["reason"] != reason {
+			continue
+		}
+		updated := amqp.Table{}
+		for key, value := range entry {
+			updated[key] = value
+		}
+		count, _ := updated["count"].(int64)
+		updated["count"] = count + 1
+		rest := append(append([]interface{}{}, deaths[:i]...), deaths[i+1:]...)
+		return append([]interface{}{updated}, rest...)
+	}
+	entry := amqp.Table{
+		"queue":        queue,
+		"reason":       reason,
+		"exchange":     exchange,
+		"routing-keys": []interface{}{routingKey},
+		"count":        int64(1),
+		"time":         time.Now(),
+	}
+	return append([]interface{}{entry}, deaths...)
+}
+
+// dispatch hand ready messages to consumers having room under their channel prefetch, round robin
+func (b *MemoryBroker) dispatch(q *memoryQueue) {
+	for len(q.ready) > 0 && len(q.consumers) > 0 {
+		var consumer *memoryConsumer
+		for i := 0; i < len(q.consumers); i++ {
+			candidate := q.consumers[(q.next+i)%len(q.consumers)]
+			if candidate.hasCapacity() {
+				consumer = candidate
+				q.next = (q.next + i + 1) % len(q.consumers)
+				break
+			}
+		}
+		if consumer == nil {
+			return
+		}
+
+		msg := q.ready[0]
+		q.ready = q.ready[1:]
+		consumer.deliver(q, msg)
+	}
+}
+
+func (b *MemoryBroker) removeConsumer(consumer *memoryConsumer) {
+	q := consumer.queue
+	for i, c := range q.consumers {
+		if c == consumer {
+			q.consumers = append(q.consumers[:i], q.consumers[i+1:]...)
+			break
+		}
+	}
+	if q.next >= len(q.consumers) {
+		q.next = 0
+	}
+
+	// deliveries not yet received by the application go back to the queue
+	for i := len(consumer.pending) - 1; i >= 0; i-- {
+		delivery := consumer.pending[i]
+		if unacked, ok := consumer.channel.unacked[delivery.DeliveryTag]; ok {
+			delete(consumer.channel.unacked, delivery.DeliveryTag)
+			b.requeue(q, unacked.message)
+		}
+	}
+	consumer.pending = nil
+	close(consumer.done)
+	b.dispatch(q)
+}
+
+func (q *memoryQueue) remove(msg *memoryMessage) bool {
+	for i, m := range q.ready {
+		if m == msg {
+			q.ready = append(q.ready[:i], q.ready[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// matchRoutingKey topic patterns support `*` for exactly one word and `#` for zero or more words
+func matchRoutingKey(kind, pattern, routingKey string) bool {
+	switch kind {
+	case amqp.ExchangeFanout:
+		return true
+	case amqp.ExchangeTopic:
+		return matchTopic(strings.Split(pattern, "."), strings.Split(routingKey, "."))
+	default:
+		return pattern == routingKey
+	}
+}
+
+func matchTopic(pattern, words []string) bool {
+	if len(pattern) == 0 {
+		return len(words) == 0
+	}
+	switch pattern[0] {
+	case "#":
+		for i := 0; i <= len(words); i++ {
+			if matchTopic(pattern[1:], words[i:]) {
+				return true
+			}
+		}
+		return false
+	case "*":
+		return len(words) > 0 && matchTopic(pattern[1:], words[1:])
+	default:
+		return len(words) > 0 && pattern[0] == words[0] && matchTopic(pattern[1:], words[1:])
+	}
+}
+
+func copyPublishing(publishing amqp.Publishing) amqp.Publishing {
+	headers := amqp.Table{}
+	for key, value := range publishing.Headers {
+		headers[key] = value
+	}
+	publishing.Headers = headers
+	publishing.Body = append([]byte(nil), publishing.Body...)
+
+	return publishing
+}
+
+type memoryConnection struct {
+	broker         *MemoryBroker
+	channels       map[*memoryChannel]bool
+	closeReceivers []chan *amqp.Error
+	closed         bool
+}
+
+func (c *memoryConnection) Channel() (Channel, error) {
+	c.broker.mu.Lock()
+	defer c.broker.mu.Unlock()
+
+	if c.closed {
+		return nil, amqp.ErrClosed
+	}
+	ch := &memoryChannel{
+		broker:    c.broker,
+		conn:      c,
+		consumers: map[string]*memoryConsumer{},
+		unacked:   map[uint64]memoryUnacked{},
+		events:    newEventLoop(),
+	}
+	c.channels[ch] = true
+
+	return ch, nil
+}
+
+func (c *memoryConnection) NotifyClose(receiver chan *amqp.Error) chan *amqp.Error {
+	c.broker.mu.Lock()
+	defer c.broker.mu.Unlock()
+
+	if c.closed {
+		close(receiver)
+		return receiver
+	}
+	c.closeReceivers = append(c.closeReceivers, receiver)
+
+	return receiver
+}
+
+func (c *memoryConnection) IsClosed() bool {
+	c.broker.mu.Lock()
+	defer c.broker.mu.Unlock()
+
+	return c.closed
+}
+
+func (c *memoryConnection) Close() error {
+	c.broker.mu.Lock()
+	defer c.broker.mu.Unlock()
+
+	if c.closed {
+		return amqp.ErrClosed
+	}
+	c.broker.closeConnection(c, nil)
+
+	return nil
+}
+
+type memoryUnacked struct {
+	queue    *memoryQueue
+	message  *memoryMessage
+	consumer *memoryConsumer
+}
+
+type memoryChannel struct {
+	broker *MemoryBroker
+	conn   *memoryConnection
+	closed bool
+
+	prefetch    int
+	deliveryTag uint64
+	unacked     map[uint64]memoryUnacked
+	consumers   map[string]*memoryConsumer
+
+	confirmMode    bool
+	publishTag     uint64
+	confirms       []chan amqp.Confirmation
+	returns        []chan amqp.Return
+	closeReceivers []chan *amqp.Error
+	events         *eventLoop
+}
+
+func (ch *memoryChannel) Qos(prefetchCount, _ int, _ bool) error {
+	ch.broker.mu.Lock()
+	defer ch.broker.mu.Unlock()
+
+	if ch.closed {
+		return amqp.ErrClosed
+	}
+	ch.prefetch = prefetchCount
+
+	return nil
+}
+
+func (ch *memoryChannel) ExchangeDeclare(name, kind string, _, _, _, _ bool, _ amqp.Table) error {
+	ch.broker.mu.Lock()
+	defer ch.broker.mu.Unlock()
+
+	if ch.closed {
+		return amqp.ErrClosed
+	}
+	switch kind {
+	case amqp.ExchangeDirect, amqp.ExchangeFanout, amqp.ExchangeTopic:
+	default:
+		return fmt.Errorf("exchange kind %s is not supported by the memory broker", kind)
+	}
+	if existing, ok := ch.broker.exchanges[name]; ok && existing != kind {
+		return ch.fail(amqp.PreconditionFailed, fmt.Sprintf("PRECONDITION_FAILED - inequivalent arg 'type' for exchange '%s'", name))
+	}
+	ch.broker.exchanges[name] = kind
+
+	return nil
+}
+
+func (ch *memoryChannel) QueueDeclare(name string, _, _, _, _ bool, args amqp.Table) (amqp.Queue, error) {
+	ch.broker.mu.Lock()
+	defer ch.broker.mu.Unlock()
+
+	if ch.closed {
+		return amqp.Queue{}, amqp.ErrClosed
+	}
+	if name == "" {
+		ch.broker.sequence++
+		name = fmt.Sprintf("amq.gen-%d", ch.broker.sequence)
+	}
+
+	q, ok := ch.broker.queues[name]
+	if !ok {
+		q = &memoryQueue{name: name}
+		if ttl, ok := toMilliseconds(args["x-message-ttl"]); ok {
+			q.ttl = ttl
+		}
+		if exchange, ok := args["x-dead-letter-exchange"].(string); ok {
+			q.deadLetterExchange = &exchange
+		}
+		q.deadLetterRoutingKey, _ = args["x-dead-letter-routing-key"].(string)
+		ch.broker.queues[name] = q
+	}
+
+	return amqp.Queue{Name: name, Messages: len(q.ready), Consumers: len(q.consumers)}, nil
+}
+
+func (ch *memoryChannel) QueueInspect(name string) (amqp.Queue, error) {
+	ch.broker.mu.Lock()
+	defer ch.broker.mu.Unlock()
+
+	if ch.closed {
+		return amqp.Queue{}, amqp.ErrClosed
+	}
+	q, ok := ch.broker.queues[name]
+	if !ok {
+		return amqp.Queue{}, ch.fail(amqp.NotFound, fmt.Sprintf("NOT_FOUND - no queue '%s'", name))
+	}
+
+	return amqp.Queue{Name: name, Messages: len(q.ready), Consumers: len(q.consumers)}, nil
+}
+
+func (ch *memoryChannel) QueueBind(name, key, exchange string, _ bool, _ amqp.Table) error {
+	ch.broker.mu.Lock()
+	defer ch.broker.mu.Unlock()
+
+	if ch.closed {
+		return amqp.ErrClosed
+	}
+	if _, ok := ch.broker.exchanges[exchange]; !ok {
+		return ch.fail(amqp.NotFound, fmt.Sprintf("NOT_FOUND - no exchange '%s'", exchange))
+	}
+	if _, ok := ch.broker.queues[name]; !ok {
+		return ch.fail(amqp.NotFound, fmt.Sprintf("NOT_FOUND - no queue '%s'", name))
+	}
+	binding := memoryBinding{queue: name, exchange: exchange, routingKey: key}
+	for _, existing := range ch.broker.bindings {
+		if existing == binding {
+			return nil
+		}
+	}
+	ch.broker.bindings = append(ch.broker.bindings, binding)
+
+	return nil
+}
+
+func (ch *memoryChannel) Confirm(_ bool) error {
+	ch.broker.mu.Lock()
+	defer ch.broker.mu.Unlock()
+
+	if ch.closed {
+		return amqp.ErrClosed
+	}
+	ch.confirmMode = true
+
+	return nil
+}
+
+func (ch *memoryChannel) NotifyPublish(confirm chan amqp.Confirmation) chan amqp.Confirmation {
+	ch.broker.mu.Lock()
+	defer ch.broker.mu.Unlock()
+
+	if ch.closed {
+		close(confirm)
+		return confirm
+	}
+	ch.confirms = append(ch.confirms, confirm)
+
+	return confirm
+}
+
+func (ch *memoryChannel) NotifyReturn(returns chan amqp.Return) chan amqp.Return {
+	ch.broker.mu.Lock()
+	defer ch.broker.mu.Unlock()
+
+	if ch.closed {
+		close(returns)
+		return returns
+	}
+	ch.returns = append(ch.returns, returns)
+
+	return returns
+}
+
+func (ch *memoryChannel) NotifyClose(receiver chan *amqp.Error) chan *amqp.Error {
+	ch.broker.mu.Lock()
+	defer ch.broker.mu.Unlock()
+
+	if ch.closed {
+		close(receiver)
+		return receiver
+	}
+	ch.closeReceivers = append(ch.closeReceivers, receiver)
+
+	return receiver
+}
+
+// Publish route the message, returns and confirms are sent asynchronously in publishing order like a real broker
+func (ch *memoryChannel) Publish(exchange, key string, mandatory, _ bool, msg amqp.Publishing) error {
+	ch.broker.mu.Lock()
+	defer ch.broker.mu.Unlock()
+
+	if ch.closed {
+		return amqp.ErrClosed
+	}
+	if _, ok := ch.broker.exchanges[exchange]; exchange != "" && !ok {
+		// like RabbitMQ the publish itself succeeds and the channel is closed asynchronously
+		ch.broker.closeChannel(ch, &amqp.Error{Code: amqp.NotFound, Reason: fmt.Sprintf("NOT_FOUND - no exchange '%s'", exchange), Server: true})
+		return nil
+	}
+
+	routed := ch.broker.route(exchange, key, msg)
+	if routed == 0 && mandatory {
+		returned := amqp.Return{
+			ReplyCode:       amqp.NoRoute,
+			ReplyText:       "NO_ROUTE",
+			Exchange:        exchange,
+			RoutingKey:      key,
+			ContentType:     msg.ContentType,
+			ContentEncoding: msg.ContentEncoding,
+			Headers:         msg.Headers,
+			DeliveryMode:    msg.DeliveryMode,
+			Priority:        msg.Priority,
+			CorrelationId:   msg.CorrelationId,
+			ReplyTo:         msg.ReplyTo,
+			Expiration:      msg.Expiration,
+			MessageId:       msg.MessageId,
+			Timestamp:       msg.Timestamp,
+			Type:            msg.Type,
+			UserId:          msg.UserId,
+			AppId:           msg.AppId,
+			Body:            msg.Body,
+		}
+		receivers := ch.returns
+		ch.events.push(func() {
+			for _, r := range receivers {
+				r <- returned
+			}
+		})
+	}
+	if ch.confirmMode {
+		ch.publishTag++
+		confirmation := amqp.Confirmation{DeliveryTag: ch.publishTag, Ack: true}
+		receivers := ch.confirms
+		ch.events.push(func() {
+			for _, c := range receivers {
+				c <- confirmation
+			}
+		})
+	}
+
+	return nil
+}
+
+func (ch *memoryChannel) Consume(queue, consumerTag string, autoAck, _, _, _ bool, _ amqp.Table) (<-chan amqp.Delivery, error) {
+	ch.broker.mu.Lock()
+	defer ch.broker.mu.Unlock()
+
+	if ch.closed {
+		return nil, amqp.ErrClosed
+	}
+	q, ok := ch.broker.queues[queue]
+	if !ok {
+		return nil, ch.fail(amqp.NotFound, fmt.Sprintf("NOT_FOUND - no queue '%s'", queue))
+	}
+	if consumerTag == "" {
+		ch.broker.sequence++
+		consumerTag = fmt.Sprintf("ctag-%d", ch.broker.sequence)
+	}
+	if _, exists := ch.consumers[consumerTag]; exists {
+		return nil, ch.fail(amqp.NotAllowed, fmt.Sprintf("NOT_ALLOWED - attempt to reuse consumer tag '%s'", consumerTag))
+	}
+
+	consumer := &memoryConsumer{
+		tag:        consumerTag,
+		channel:    ch,
+		queue:      q,
+		autoAck:    autoAck,
+		deliveries: make(chan amqp.Delivery),
+		signal:     make(chan struct{}, 1),
+		done:       make(chan struct{}),
+	}
+	ch.consumers[consumerTag] = consumer
+	q.consumers = append(q.consumers, consumer)
+	go consumer.run()
+	ch.broker.dispatch(q)
+
+	return consumer.deliveries, nil
+}
+
+func (ch *memoryChannel) Cancel(consumerTag string, _ bool) error {
+	ch.broker.mu.Lock()
+	defer ch.broker.mu.Unlock()
+
+	if ch.closed {
+		return amqp.ErrClosed
+	}
+	if consumerTag == "" {
+		// mirrors streadway which cancels nothing known by an empty tag
+		return nil
+	}
+	consumer, ok := ch.consumers[consumerTag]
+	if !ok {
+		return nil
+	}
+	delete(ch.consumers, consumerTag)
+	ch.broker.removeConsumer(consumer)
+
+	return nil
+}
+
+func (ch *memoryChannel) Close() error {
+	ch.broker.mu.Lock()
+	defer ch.broker.mu.Unlock()
+
+	if ch.closed {
+		return amqp.ErrClosed
+	}
+	ch.broker.closeChannel(ch, nil)
+
+	return nil
+}
+
+func (ch *memoryChannel) Ack(tag uint64, multiple bool) error {
+	return ch.settle(tag, multiple, func(unacked memoryUnacked) {})
+}
+
+func (ch *memoryChannel) Nack(tag uint64, multiple bool, requeue bool) error {
+	return ch.settle(tag, multiple, ch.reject(requeue))
+}
+
+func (ch *memoryChannel) Reject(tag uint64, requeue bool) error {
+	return ch.settle(tag, false, ch.reject(requeue))
+}
+
+func (ch *memoryChannel) reject(requeue bool) func(unacked memoryUnacked) {
+	return func(unacked memoryUnacked) {
+		if requeue {
+			ch.broker.requeue(unacked.queue, unacked.message)
+			return
+		}
+		ch.broker.deadLetter(unacked.queue, unacked.message, "rejected")
+	}
+}
+
+func (ch *memoryChannel) settle(tag uint64, multiple bool, apply func(unacked memoryUnacked)) error {
+	ch.broker.mu.Lock()
+	defer ch.broker.mu.Unlock()
+
+	if ch.closed {
+		return amqp.ErrClosed
+	}
+
+	tags := []uint64{tag}
+	if multiple {
+		tags = tags[:0]
+		for t := uint64(1); t <= tag; t++ {
+			if _, ok := ch.unacked[t]; ok {
+				tags = append(tags, t)
+			}
+		}
+	} else if _, ok := ch.unacked[tag]; !ok {
+		return ErrUnknownDeliveryTag
+	}
+
+	touched := map[*memoryQueue]bool{}
+	for i := len(tags) - 1; i >= 0; i-- {
+		t := tags[i]
+		unacked := ch.unacked[t]
+		delete(ch.unacked, t)
+		unacked.consumer.unacked--
+		apply(unacked)
+		touched[unacked.queue] = true
+	}
+	for q := range touched {
+		ch.broker.dispatch(q)
+	}
+
+	return nil
+}
+
+// fail close the channel with a server error like RabbitMQ does on a failed method
+func (ch *memoryChannel) fail(code int, reason string) error {
+	err := &amqp.Error{Code: code, Reason: reason, Server: true}
+	ch.broker.closeChannel(ch, err)
+
+	return err
+}
+
+type memoryConsumer struct {
+	tag        string
+	channel    *memoryChannel
+	queue      *memoryQueue
+	autoAck    bool
+	unacked    int
+	pending    []amqp.Delivery
+	deliveries chan amqp.Delivery
+	signal     chan struct{}
+	done       chan struct{}
+}
+
+func (c *memoryConsumer) hasCapacity() bool {
+	return c.autoAck || c.channel.prefetch <= 0 || c.unacked < c.channel.prefetch
+}
+
+func (c *memoryConsumer) deliver(q *memoryQueue, msg *memoryMessage) {
+	ch := c.channel
+	ch.deliveryTag++
+	if !c.autoAck {
+		ch.unacked[ch.deliveryTag] = memoryUnacked{queue: q, message: msg, consumer: c}
+		c.unacked++
+	}
+
+	p := msg.publishing
+	c.pending = append(c.pending, amqp.Delivery{
+		Acknowledger:    ch,
+		Headers:         p.Headers,
+		ContentType:     p.ContentType,
+		ContentEncoding: p.ContentEncoding,
+		DeliveryMode:    p.DeliveryMode,
+		Priority:        p.Priority,
+		CorrelationId:   p.CorrelationId,
+		ReplyTo:         p.ReplyTo,
+		Expiration:      p.Expiration,
+		MessageId:       p.MessageId,
+		Timestamp:       p.Timestamp,
+		Type:            p.Type,
+		UserId:          p.UserId,
+		AppId:           p.AppId,
+		ConsumerTag:     c.tag,
+		DeliveryTag:     ch.deliveryTag,
+		Redelivered:     msg.redelivered,
+		Exchange:        msg.exchange,
+		RoutingKey:      msg.routingKey,
+		Body:            p.Body,
+	})
+	select {
+	case c.signal <- struct{}{}:
+	default:
+	}
+}
+
+// run forward pending deliveries to the application until the consumer is cancelled
+func (c *memoryConsumer) run() {
+	defer close(c.deliveries)
+	broker := c.channel.broker
+	for {
+		broker.mu.Lock()
+		if len(c.pending) == 0 {
+			broker.mu.Unlock()
+			select {
+			case <-c.signal:
+				continue
+			case <-c.done:
+				return
+			}
+		}
+		delivery := c.pending[0]
+		c.pending = c.pending[1:]
+		broker.mu.Unlock()
+
+		select {
+		case c.deliveries <- delivery:
+		case <-c.done:
+			broker.mu.Lock()
+			if unacked, ok := c.channel.unacked[delivery.DeliveryTag]; ok {
+				delete(c.channel.unacked, delivery.DeliveryTag)
+				broker.requeue(unacked.queue, unacked.message)
+				broker.dispatch(unacked.queue)
+			}
+			broker.mu.Unlock()
+			return
+		}
+	}
+}
+
+// eventLoop run callbacks one by one in a dedicated goroutine, keeping their order
+type eventLoop struct {
+	mu      sync.Mutex
+	events  []func()
+	signal  chan struct{}
+	stopped bool
+}
+
+func newEventLoop() *eventLoop {
+	loop := &eventLoop{signal: make(chan struct{}, 1)}
+	go loop.run()
+
+	return loop
+}
+
+func (l *eventLoop) push(event func()) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.stopped {
+		return
+	}
+	l.events = append(l.events, event)
+	select {
+	case l.signal <- struct{}{}:
+	default:
+	}
+}
+
+// stop the loop once the events already pushed are run
+func (l *eventLoop) stop() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.stopped = true
+	select {
+	case l.signal <- struct{}{}:
+	default:
+	}
+}
+
+func (l *eventLoop) run() {
+	for range l.signal {
+		l.mu.Lock()
+		events := l.events
+		l.events = nil
+		stopped := l.stopped
+		l.mu.Unlock()
+
+		for _, event := range events {
+			event()
+		}
+		if stopped {
+			return
+		}
+	}
+}
+
+func toMilliseconds(value interface{}) (time.Duration, bool) {
+	switch v := value.(type) {
+	case int:
+		return time.Duration(v) * time.Millisecond, true
+	case int32:
+		return time.Duration(v) * time.Millisecond, true
+	case int64:
+		return time.Duration(v) * time.Millisecond, true
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,212 @@
+package transport
+
+import (
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+func openChannel(t *testing.T, broker *MemoryBroker) (Connection, Channel) {
+	conn, err := broker.Dial("")
+	if err != nil {
+		t.Fatalf("dial: %s", err)
+	}
+	ch, err := conn.Channel()
+	if err != nil {
+		t.Fatalf("open channel: %s", err)
+	}
+	return conn, ch
+}
+
+func receive(t *testing.T, deliveries <-chan amqp.Delivery) amqp.Delivery {
+	select {
+	case d, ok := <-deliveries:
+		if !ok {
+			t.Fatal("delivery channel closed")
+		}
+		return d
+	case <-time.After(time.Second):
+		t.Fatal("no delivery received")
+	}
+	return amqp.Delivery{}
+}
+
+func TestMemoryBroker_PublishConsumeAck(t *testing.T) {
+	broker := NewMemoryBroker()
+	_, ch := openChannel(t, broker)
+
+	if _, err := ch.QueueDeclare("package_creation", true, false, false, false, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := ch.Publish("", "package_creation", false, false, amqp.Publishing{MessageId: "1", Body: []byte("{}")}); err != nil {
+		t.Fatal(err)
+	}
+
+	deliveries, err := ch.Consume("package_creation", "", false, false, false, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := receive(t, deliveries)
+	if d.MessageId != "1" || d.RoutingKey != "package_creation" || d.Redelivered {
+		t.Errorf("wrong delivery: %+v", d)
+	}
+	if err := d.Ack(false); err != nil {
+		t.Errorf("ack failed: %s", err)
+	}
+	if err := d.Ack(false); err != ErrUnknownDeliveryTag {
+		t.Errorf("expect double ack to fail, got: %v", err)
+	}
+	if broker.MessageCount("package_creation") != 0 {
+		t.Error("expect queue to be empty")
+	}
+}
+
+func TestMemoryBroker_ConfirmAndReturn(t *testing.T) {
+	broker := NewMemoryBroker()
+	_, ch := openChannel(t, broker)
+
+	if err := ch.Confirm(false); err != nil {
+		t.Fatal(err)
+	}
+	confirms := ch.NotifyPublish(make(chan amqp.Confirmation, 1))
+	returns := ch.NotifyReturn(make(chan amqp.Return, 1))
+
+	if err := ch.Publish("", "missing_queue", true, false, amqp.Publishing{MessageId: "1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case r := <-returns:
+		if r.RoutingKey != "missing_queue" || r.MessageId != "1" || r.ReplyCode != amqp.NoRoute {
+			t.Errorf("wrong return: %+v", r)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no return received")
+	}
+	select {
+	case c := <-confirms:
+		if c.DeliveryTag != 1 || !c.Ack {
+			t.Errorf("wrong confirmation: %+v", c)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no confirmation received")
+	}
+}
+
+func TestMemoryBroker_TopicRouting(t *testing.T) {
+	broker := NewMemoryBroker()
+	_, ch := openChannel(t, broker)
+
+	_ = ch.ExchangeDeclare("package", amqp.ExchangeTopic, true, false, false, false, nil)
+	for _, q := range []string{"all", "created", "single"} {
+		_, _ = ch.QueueDeclare(q, true, false, false, false, nil)
+	}
+	_ = ch.QueueBind("all", "package.#", "package", false, nil)
+	_ = ch.QueueBind("created", "package.*.created", "package", false, nil)
+	_ = ch.QueueBind("single", "package.*", "package", false, nil)
+
+	_ = ch.Publish("package", "package.fbl.created", false, false, amqp.Publishing{})
+	_ = ch.Publish("package", "package.updated", false, false, amqp.Publishing{})
+
+	expect := map[string]int{"all": 2, "created": 1, "single": 1}
+	for q, count := range expect {
+		if broker.MessageCount(q) != count {
+			t.Errorf("queue %s: expect %d messages, got %d", q, count, broker.MessageCount(q))
+		}
+	}
+}
+
+func TestMemoryBroker_DropConnectionsRequeue(t *testing.T) {
+	broker := NewMemoryBroker()
+	conn, ch := openChannel(t, broker)
+	closed := conn.NotifyClose(make(chan *amqp.Error, 1))
+
+	_, _ = ch.QueueDeclare("package_creation", true, false, false, false, nil)
+	_ = ch.Publish("", "package_creation", false, false, amqp.Publishing{MessageId: "1"})
+	deliveries, _ := ch.Consume("package_creation", "", false, false, false, false, nil)
+	d := receive(t, deliveries)
+
+	broker.DropConnections()
+
+	if err := <-closed; err != ErrConnectionForced {
+		t.Errorf("expect connection forced error, got: %v", err)
+	}
+	if err := d.Ack(false); err != amqp.ErrClosed {
+		t.Errorf("expect ack on closed channel to fail, got: %v", err)
+	}
+	if _, ok := <-deliveries; ok {
+		t.Error("expect delivery channel to be closed")
+	}
+
+	_, ch = openChannel(t, broker)
+	deliveries, _ = ch.Consume("package_creation", "", false, false, false, false, nil)
+	d = receive(t, deliveries)
+	if d.MessageId != "1" || !d.Redelivered {
+		t.Errorf("expect message to be redelivered, got: %+v", d)
+	}
+}
+
+func TestMemoryBroker_Prefetch(t *testing.T) {
+	broker := NewMemoryBroker()
+	_, ch := openChannel(t, broker)
+
+	_ = ch.Qos(1, 0, false)
+	_, _ = ch.QueueDeclare("package_creation", true, false, false, false, nil)
+	_ = ch.Publish("", "package_creation", false, false, amqp.Publishing{MessageId: "1"})
+	_ = ch.Publish("", "package_creation", false, false, amqp.Publishing{MessageId: "2"})
+
+	deliveries, _ := ch.Consume("package_creation", "", false, false, false, false, nil)
+	d := receive(t, deliveries)
+	if broker.MessageCount("package_creation") != 1 {
+		t.Error("expect second message to wait for the first ack")
+	}
+	_ = d.Nack(false, true)
+
+	d = receive(t, deliveries)
+	if d.MessageId != "1" || !d.Redelivered {
+		t.Errorf("expect nacked message to be redelivered first, got: %+v", d)
+	}
+}
+
+func TestMemoryBroker_QueueInspect(t *testing.T) {
+	broker := NewMemoryBroker()
+	_, ch := openChannel(t, broker)
+
+	_, _ = ch.QueueDeclare("package_creation", true, false, false, false, nil)
+	_ = ch.Publish("", "package_creation", false, false, amqp.Publishing{MessageId: "1"})
+	_ = ch.Publish("", "package_creation", false, false, amqp.Publishing{MessageId: "2"})
+
+	q, err := ch.QueueInspect("package_creation")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.Messages != 2 || q.Consumers != 0 {
+		t.Errorf("expect 2 ready messages and no consumer, got: %+v", q)
+	}
+	if _, err := ch.QueueInspect("unknown"); err == nil {
+		t.Error("expect inspecting an unknown queue to fail")
+	}
+	if _, err := ch.QueueInspect("package_creation"); err != amqp.ErrClosed {
+		t.Errorf("expect the channel closed by the failure, got: %v", err)
+	}
+}
+
+func TestMemoryBroker_TTLDeadLetter(t *testing.T) {
+	broker := NewMemoryBroker()
+	_, ch := openChannel(t, broker)
+
+	_, _ = ch.QueueDeclare("package_creation", true, false, false, false, nil)
+	_, _ = ch.QueueDeclare("package_creation.delayed", true, false, false, false, amqp.Table{
+		"x-message-ttl":             int64(10),
+		"x-dead-letter-exchange":    "",
+		"x-dead-letter-routing-key": "package_creation",
+	})
+	_ = ch.Publish("", "package_creation.delayed", false, false, amqp.Publishing{MessageId: "1"})
+
+	deliveries, _ := ch.Consume("package_creation", "", false, false, false, false, nil)
+	d := receive(t, deliveries)
+	if d.MessageId != "1" || d.Headers["x-first-death-reason"] != "expired" {
+		t.Errorf("expect expired message to be dead-lettered, got: %+v", d)
+	}
+}
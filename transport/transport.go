@@ -0,0 +1,71 @@
+package transport
+
+import (
+	"github.com/streadway/amqp"
+)
+
+// Transport open connections to a broker
+type Transport interface {
+	Dial(url string) (Connection, error)
+}
+
+// Connection connection to a broker
+type Connection interface {
+	Channel() (Channel, error)
+	// NotifyClose receives the error when the connection is lost, the channel is closed on a graceful Close
+	NotifyClose(receiver chan *amqp.Error) chan *amqp.Error
+	IsClosed() bool
+	Close() error
+}
+
+// Channel channel opened on a Connection, *amqp.Channel implements it.
+// Deliveries are acked, nacked and rejected through their amqp.Acknowledger
+type Channel interface {
+	Qos(prefetchCount, prefetchSize int, global bool) error
+
+	ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error
+	QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error)
+	QueueBind(name, key, exchange string, noWait bool, args amqp.Table) error
+	// QueueInspect messages ready and consumers of an existing queue, the channel is closed when it does not exist
+	QueueInspect(name string) (amqp.Queue, error)
+
+	// Confirm put the channel into confirm mode, every publishing is then acked or nacked through NotifyPublish
+	Confirm(noWait bool) error
+	NotifyPublish(confirm chan amqp.Confirmation) chan amqp.Confirmation
+	// NotifyReturn receives mandatory publishings which could not be routed
+	NotifyReturn(returns chan amqp.Return) chan amqp.Return
+	Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error
+
+	Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp.Table) (<-chan amqp.Delivery, error)
+	Cancel(consumer string, noWait bool) error
+
+	NotifyClose(receiver chan *amqp.Error) chan *amqp.Error
+	Close() error
+}
+
+// AMQP transport backed by github.com/streadway/amqp, it is the default one
+var AMQP Transport = amqpTransport{}
+
+type amqpTransport struct{}
+
+func (amqpTransport) Dial(url string) (Connection, error) {
+	conn, err := amqp.Dial(url)
+	if err != nil {
+		return nil, err
+	}
+
+	return &amqpConnection{Connection: conn}, nil
+}
+
+type amqpConnection struct {
+	*amqp.Connection
+}
+
+func (c *amqpConnection) Channel() (Channel, error) {
+	channel, err := c.Connection.Channel()
+	if err != nil {
+		return nil, err
+	}
+
+	return channel, nil
+}